@@ -0,0 +1,448 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/peterbourgon/field"
+)
+
+const (
+	arpInterval  = 2 * time.Millisecond // how often an arp checks for steps, while it's playing
+	keyQueueSize = 64                   // keys an arp gets ahead of a target by
+)
+
+// keyReceiver is a node that can be played by another node.
+type keyReceiver interface {
+	identifier
+	keyDown(keyEvent)
+	keyUp(keyEvent)
+}
+
+// arp is an arpeggiator. It takes held keys like a generator does, and plays
+// them one at a time into the keyReceivers it's connected to, in steps of a
+// note value synced to the clock. It plays them through keyQueues, so it's
+// never held up on the clock's tick by a target that's busy.
+type arp struct {
+	id            string
+	clock         *clock
+	params        *params
+	keyDownEvents chan keyEvent
+	keyUpEvents   chan keyEvent
+	held          []keyEvent // in the order pressed
+	step          int        // index into the pattern
+	sounding      *keyEvent  // the note that's down in the targets, if any
+	beat          float64    // musical position, in beats
+	pos           position   // of the clock's last pulse
+	nextStep      float64    // beat of the next step
+	gateOff       float64    // beat to lift the sounding note
+	bpm           float32
+	last          time.Time              // when beat was last moved on
+	targets       map[string]keyReceiver // keyQueues, once connected
+	connects      chan connectKeysRequest
+	discons       chan string
+	ticks         chan position
+	tempos        chan float32
+	quit          chan chan struct{}
+}
+
+func init() {
+	registerNodeType(nodeType{
+		name:        "arp",
+		description: "arpeggiator, synced to the clock, that plays other nodes",
+		new:         func(id string, p *platform) field.Node { return newArp(id, p.clock) },
+	})
+}
+
+func newArp(id string, c *clock) *arp {
+	a := &arp{
+		id:    id,
+		clock: c,
+		pos:   c.position(),
+		params: newParams(
+			enumP("mode", "up", "up", "down", "updown", "random", "played"),
+			intP("octaves", 1, 4, 1),
+			floatP("gate", 0.01, 1, 0.5, ""),          // of a step
+			floatP("rate", 1.0/64, 16, 0.25, "beats"), // step length
+		),
+		keyDownEvents: make(chan keyEvent),
+		keyUpEvents:   make(chan keyEvent),
+		held:          []keyEvent{},
+		targets:       map[string]keyReceiver{},
+		connects:      make(chan connectKeysRequest),
+		discons:       make(chan string),
+		ticks:         make(chan position),
+		tempos:        make(chan float32),
+		quit:          make(chan chan struct{}),
+	}
+	go a.loop()
+	c.subscribe(a)
+	return a
+}
+
+func (a *arp) stop() {
+	a.clock.unsubscribe(a) // first, so the clock isn't stuck ticking us
+	q := make(chan struct{})
+	a.quit <- q
+	<-q
+}
+
+func (a *arp) loop() {
+	log.Printf("%s: started", a.ID())
+	defer log.Printf("%s: done", a.ID())
+
+	// The ticker moves the arp on between pulses, and only runs while there's
+	// something to play or lift. Its channel is nil otherwise, which blocks
+	// forever in a select.
+	var t *time.Ticker
+	defer func() {
+		if t != nil {
+			t.Stop()
+		}
+	}()
+	a.last = time.Now()
+
+	for {
+		playing := len(a.held) > 0 || a.sounding != nil
+		switch {
+		case playing && t == nil:
+			t = time.NewTicker(arpInterval)
+		case !playing && t != nil:
+			t.Stop()
+			t = nil
+		}
+		var ticks <-chan time.Time
+		if t != nil {
+			ticks = t.C
+		}
+
+		select {
+		case now := <-ticks:
+			a.elapse(now)
+
+		case pos := <-a.ticks:
+			a.align(pos)
+
+		case bpm := <-a.tempos:
+			a.bpm = bpm
+
+		case k := <-a.keyDownEvents:
+			a.press(k)
+
+		case k := <-a.keyUpEvents:
+			a.release(k)
+
+		case r := <-a.connects:
+			if _, ok := a.targets[r.r.ID()]; ok {
+				r.e <- fmt.Errorf("%s already connected to %s", a.ID(), r.r.ID())
+				continue
+			}
+			a.targets[r.r.ID()] = newKeyQueue(r.r)
+			r.e <- nil
+			log.Printf("%s → %s", a.ID(), r.r.ID())
+
+		case id := <-a.discons:
+			if t, ok := a.targets[id]; ok {
+				if a.sounding != nil {
+					t.keyUp(*a.sounding)
+				}
+				closeTarget(t)
+			}
+			delete(a.targets, id)
+			log.Printf("%s ✕ %s", a.ID(), id)
+
+		case q := <-a.quit:
+			a.lift()
+			for _, t := range a.targets {
+				closeTarget(t)
+			}
+			close(q)
+			return
+		}
+	}
+}
+
+// elapse moves the arp on to now, between pulses, and plays whatever's due.
+func (a *arp) elapse(now time.Time) {
+	a.beat += now.Sub(a.last).Seconds() * float64(a.bpm) / 60
+	a.last = now
+	if a.pos.ppqn > 0 {
+		// Don't run ahead to the next pulse, which may be late with
+		// groove, so steps on it wait for it.
+		a.beat = math.Min(a.beat, float64(a.pos.pulse+1)/float64(a.pos.ppqn)-1e-6)
+	}
+	a.advance()
+}
+
+// align re-aligns the arp on a pulse, and plays whatever's due.
+func (a *arp) align(pos position) {
+	a.beat, a.last, a.pos = pos.beats(), time.Now(), pos
+	a.advance()
+}
+
+func (a *arp) press(k keyEvent) {
+	if len(a.held) <= 0 {
+		rate := a.params.float("rate")
+		a.step, a.nextStep = 0, math.Ceil(a.beat/rate)*rate // start on the grid
+	}
+	a.held = append(removeKeyEvent(a.held, k.midi), k)
+}
+
+// release lets go of a key, or all of them for key 0.
+func (a *arp) release(k keyEvent) {
+	if k.midi == 0 {
+		a.held = []keyEvent{} // reset
+	} else {
+		a.held = removeKeyEvent(a.held, k.midi)
+	}
+	if len(a.held) <= 0 {
+		a.lift()
+	}
+}
+
+// advance plays whatever steps and gates are due at the current beat.
+func (a *arp) advance() {
+	if a.sounding != nil && a.beat >= a.gateOff {
+		a.lift()
+	}
+	if len(a.held) <= 0 || a.beat < a.nextStep {
+		return
+	}
+
+	rate := a.params.float("rate")
+	pattern := a.pattern()
+	a.lift()
+	k := pattern[a.step%len(pattern)]
+	if a.params.enum("mode") == "random" {
+		k = pattern[rand.Intn(len(pattern))]
+	}
+	a.step++
+	if a.pos.ppqn > 0 { // there's been a pulse to say where we are
+		k.velocity = float32(math.Max(0, math.Min(1, float64(k.velocity)+a.clock.accent(a.pos))))
+	}
+	for _, t := range a.targets {
+		t.keyDown(k)
+	}
+	a.sounding = &k
+	a.gateOff = a.nextStep + a.params.float("gate")*rate
+
+	// Keep steps on the grid, even if we've fallen behind.
+	a.nextStep = (math.Floor(a.beat/rate+1e-9) + 1) * rate
+}
+
+// lift releases the sounding note, if any.
+func (a *arp) lift() {
+	if a.sounding == nil {
+		return
+	}
+	for _, t := range a.targets {
+		t.keyUp(*a.sounding)
+	}
+	a.sounding = nil
+}
+
+// pattern is the sequence of notes the arp steps through, for the held keys.
+func (a *arp) pattern() []keyEvent {
+	keys := append([]keyEvent{}, a.held...)
+	mode := a.params.enum("mode")
+	if mode != "played" {
+		sort.Sort(keyEventsByMidi(keys))
+	}
+
+	pattern := []keyEvent{}
+	for o := 0; o < a.params.int("octaves"); o++ {
+		for _, k := range keys {
+			pattern = append(pattern, keyEvent{k.midi + float64(12*o), k.velocity})
+		}
+	}
+
+	switch mode {
+	case "down":
+		reverseKeyEvents(pattern)
+	case "updown":
+		if n := len(pattern); n > 2 {
+			down := append([]keyEvent{}, pattern[1:n-1]...)
+			reverseKeyEvents(down)
+			pattern = append(pattern, down...)
+		}
+	}
+	return pattern
+}
+
+func (a *arp) parse(input string) {
+	input = strings.TrimSpace(strings.ToLower(input))
+	toks := strings.Split(input, " ")
+	if len(toks) <= 0 {
+		log.Printf("%s: parse empty", a.ID())
+		return
+	}
+
+	switch toks[0] {
+	case "keydown", "kd", "down", "d":
+		k, err := parseKeyEvent(toks)
+		if err != nil {
+			log.Printf("%s: %s: %s", a.ID(), input, err)
+			return
+		}
+		a.keyDown(k)
+
+	case "keyup", "ku", "up", "u":
+		k, err := parseKeyEvent(toks)
+		if err != nil {
+			log.Printf("%s: %s: %s", a.ID(), input, err)
+			return
+		}
+		a.keyUp(k)
+
+	case "reset":
+		a.keyUp(keyEvent{})
+
+	case "rate":
+		if len(toks) < 2 {
+			log.Printf("%s: %s: not enough", a.ID(), input)
+			return
+		}
+		beats, err := parseNoteValue(toks[1])
+		if err != nil {
+			log.Printf("%s: %s: %s", a.ID(), input, err)
+			return
+		}
+		setParam(a, []string{"rate", strconv.FormatFloat(beats, 'f', -1, 64)})
+
+	default:
+		if !a.params.has(toks[0]) {
+			log.Printf("%s: %s: aroo", a.ID(), input)
+			return
+		}
+		setParam(a, toks)
+	}
+}
+
+func (a *arp) ID() string          { return a.id }
+func (a *arp) parameters() *params { return a.params }
+
+func (a *arp) keyDown(k keyEvent) { a.keyDownEvents <- k }
+func (a *arp) keyUp(k keyEvent)   { a.keyUpEvents <- k }
+
+func (a *arp) tick(p position)   { a.ticks <- p }
+func (a *arp) tempo(bpm float32) { a.tempos <- bpm }
+
+// controls implements the controller interface.
+func (a *arp) controls(n field.Node) bool {
+	_, ok := n.(keyReceiver)
+	return ok
+}
+
+func (a *arp) Connect(n field.Node) error {
+	r, ok := n.(keyReceiver)
+	if !ok {
+		return fmt.Errorf("%s not keyReceiver", n.ID())
+	}
+	req := connectKeysRequest{r, make(chan error)}
+	a.connects <- req
+	return <-req.e
+}
+
+func (a *arp) Disconnect(n field.Node) {
+	a.discons <- n.ID()
+}
+
+func (a *arp) Connection(n field.Node) error {
+	log.Printf("%s: Connection(%s): no", a.ID(), n.ID())
+	return errNo
+}
+
+func (a *arp) Disconnection(n field.Node) {}
+
+type connectKeysRequest struct {
+	r keyReceiver
+	e chan error
+}
+
+// keyQueue plays keys into a receiver from its own goroutine, in order, so
+// whoever's playing it doesn't wait on the receiver. If the receiver falls
+// keyQueueSize keys behind, keys are dropped until it catches up, and then
+// it's reset, so it isn't left holding a key whose keyUp was dropped.
+type keyQueue struct {
+	receiver keyReceiver
+	c        chan keyMessage
+	dropped  bool
+}
+
+type keyMessage struct {
+	down bool
+	k    keyEvent
+}
+
+func newKeyQueue(r keyReceiver) *keyQueue {
+	q := &keyQueue{
+		receiver: r,
+		c:        make(chan keyMessage, keyQueueSize),
+	}
+	go func() {
+		for m := range q.c {
+			if m.down {
+				r.keyDown(m.k)
+			} else {
+				r.keyUp(m.k)
+			}
+		}
+	}()
+	return q
+}
+
+func (q *keyQueue) ID() string         { return q.receiver.ID() }
+func (q *keyQueue) keyDown(k keyEvent) { q.send(keyMessage{true, k}) }
+func (q *keyQueue) keyUp(k keyEvent)   { q.send(keyMessage{false, k}) }
+
+func (q *keyQueue) send(m keyMessage) {
+	if q.dropped && len(q.c)+2 > cap(q.c) {
+		return // no room for the reset and m yet
+	}
+	if q.dropped {
+		log.Printf("%s: caught up, resetting", q.ID())
+		q.c <- keyMessage{false, keyEvent{}}
+		q.dropped = false
+	}
+	select {
+	case q.c <- m:
+	default:
+		log.Printf("%s: not keeping up, dropping keys", q.ID())
+		q.dropped = true
+	}
+}
+
+// closeTarget stops playing a target, once it's been played what's queued.
+func closeTarget(r keyReceiver) {
+	if q, ok := r.(*keyQueue); ok {
+		close(q.c)
+	}
+}
+
+type keyEventsByMidi []keyEvent
+
+func (s keyEventsByMidi) Len() int           { return len(s) }
+func (s keyEventsByMidi) Less(i, j int) bool { return s[i].midi < s[j].midi }
+func (s keyEventsByMidi) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+func reverseKeyEvents(s []keyEvent) {
+	for i, j := 0, len(s)-1; i < j; i, j = i+1, j-1 {
+		s[i], s[j] = s[j], s[i]
+	}
+}
+
+func removeKeyEvent(keys []keyEvent, midi float64) []keyEvent {
+	out := make([]keyEvent, 0, len(keys))
+	for _, k := range keys {
+		if k.midi != midi {
+			out = append(out, k)
+		}
+	}
+	return out
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// keyRecorder is a keyReceiver that keeps what it's played.
+type keyRecorder struct {
+	mtx   sync.Mutex
+	downs []keyEvent
+	ups   []keyEvent
+}
+
+func (r *keyRecorder) ID() string { return "recorder" }
+
+func (r *keyRecorder) keyDown(k keyEvent) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	r.downs = append(r.downs, k)
+}
+
+func (r *keyRecorder) keyUp(k keyEvent) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	r.ups = append(r.ups, k)
+}
+
+func (r *keyRecorder) played() []keyEvent {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	return append([]keyEvent{}, r.downs...)
+}
+
+func TestArpBeforeFirstPulse(t *testing.T) {
+	c := newClock(1) // a long way off the first pulse
+	defer c.stop()
+	c.parse("swing 66")
+	a := newArp("arp", c)
+	defer a.stop()
+	r := &keyRecorder{}
+	req := connectKeysRequest{r, make(chan error)}
+	a.connects <- req
+	if err := <-req.e; err != nil {
+		t.Fatal(err)
+	}
+
+	a.keyDown(keyEvent{60, 1})
+	deadline := time.Now().Add(time.Second)
+	for len(r.played()) <= 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if got := r.played(); len(got) != 1 || got[0].midi != 60 {
+		t.Errorf("expected 60 played at once, got %v", got)
+	}
+}
+
+func TestArpAccentWithoutPosition(t *testing.T) {
+	c := newClock(120)
+	defer c.stop()
+	c.parse("swing 66")
+	a := newArp("arp", c)
+	a.stop() // so we can drive it
+	r := &keyRecorder{}
+	a.targets[r.ID()] = r
+
+	a.pos, a.held = position{}, []keyEvent{{60, 0.5}}
+	a.advance()
+	if got := r.played(); len(got) != 1 || got[0].velocity != 0.5 {
+		t.Errorf("expected 60 at 0.5, got %v", got)
+	}
+}
+
+func TestArpPattern(t *testing.T) {
+	c := newClock(120)
+	defer c.stop()
+	a := newArp("arp", c)
+	a.stop() // so we can drive it
+
+	held := []keyEvent{{64, 1}, {60, 1}, {67, 1}} // in the order pressed
+	for _, x := range []struct {
+		mode     string
+		octaves  string
+		expected []float64
+	}{
+		{"up", "1", []float64{60, 64, 67}},
+		{"down", "1", []float64{67, 64, 60}},
+		{"updown", "1", []float64{60, 64, 67, 64}},
+		{"played", "1", []float64{64, 60, 67}},
+		{"random", "1", []float64{60, 64, 67}}, // picked from at random
+		{"up", "2", []float64{60, 64, 67, 72, 76, 79}},
+		{"down", "2", []float64{79, 76, 72, 67, 64, 60}},
+		{"updown", "2", []float64{60, 64, 67, 72, 76, 79, 76, 72, 67, 64}},
+		{"played", "2", []float64{64, 60, 67, 76, 72, 79}},
+	} {
+		a.params.set("mode", x.mode)
+		a.params.set("octaves", x.octaves)
+		a.held = held
+		got := []float64{}
+		for _, k := range a.pattern() {
+			got = append(got, k.midi)
+		}
+		if !reflect.DeepEqual(got, x.expected) {
+			t.Errorf("%s, %s octaves: expected %v, got %v", x.mode, x.octaves, x.expected, got)
+		}
+	}
+
+	a.held = []keyEvent{{60, 1}, {62, 1}}
+	a.params.set("mode", "updown")
+	a.params.set("octaves", "1")
+	if got := len(a.pattern()); got != 2 {
+		t.Errorf("updown of 2 keys: expected 2 steps, got %d", got)
+	}
+}
+
+func TestArpSteps(t *testing.T) {
+	for _, x := range []struct {
+		name     string
+		commands []string
+		groove   *groove
+		pressAt  uint64 // pulse, at 24 ppqn
+		expected []string
+	}{
+		{
+			"8ths, half gate",
+			[]string{"rate 1/8", "gate 0.5"},
+			nil, 0,
+			[]string{"0 d60", "6 u60", "12 d64", "18 u64", "24 d67", "30 u67", "36 d60", "42 u60", "48 d64"},
+		},
+		{
+			"quarters, full gate, pressed off the grid",
+			[]string{"rate 1/4", "gate 1"},
+			nil, 5,
+			[]string{"24 d60", "48 u60", "48 d64"},
+		},
+		{
+			"16ths, down",
+			[]string{"rate 1/16", "gate 0.5", "mode down"},
+			nil, 0,
+			[]string{"0 d67", "3 u67", "6 d64", "9 u64", "12 d60", "15 u60", "18 d67", "21 u67", "24 d64", "27 u64",
+				"30 d60", "33 u60", "36 d67", "39 u67", "42 d64", "45 u64", "48 d60"},
+		},
+		{
+			"quarters, with a groove's velocity",
+			[]string{"rate 1/4", "gate 0.5"},
+			&groove{unit: 1, timing: []float64{0, 0}, velocity: []float64{0, -0.75}},
+			0,
+			[]string{"0 d60@1", "12 u60", "24 d64@0.25", "36 u64", "48 d67@1"},
+		},
+	} {
+		c := newClock(120)
+		if x.groove != nil {
+			c.loadGroove("test", x.groove)
+			c.parse("groove test")
+		}
+		a := newArp("arp", c)
+		a.stop() // so we can drive it
+		r := &eventRecorder{}
+		a.targets[r.ID()] = r
+		for _, cmd := range x.commands {
+			a.parse(cmd)
+		}
+
+		for n := uint64(0); n <= 48; n++ {
+			r.pulse = n
+			if n == x.pressAt {
+				for _, k := range []float64{60, 64, 67} {
+					a.press(keyEvent{k, 1})
+				}
+			}
+			a.align(position{pulse: n, bar: 1, beat: int(n/24) + 1, tick: int(n % 24), meter: meter{4, 4}, ppqn: 24})
+		}
+		if x.groove == nil {
+			for i := range r.events {
+				r.events[i] = strings.Split(r.events[i], "@")[0]
+			}
+		}
+		if !reflect.DeepEqual(r.events, x.expected) {
+			t.Errorf("%s: expected %v, got %v", x.name, x.expected, r.events)
+		}
+		c.stop()
+	}
+}
+
+// eventRecorder is a keyReceiver that notes what it's played, and when.
+type eventRecorder struct {
+	pulse  uint64
+	events []string
+}
+
+func (r *eventRecorder) ID() string { return "recorder" }
+
+func (r *eventRecorder) keyDown(k keyEvent) {
+	r.events = append(r.events, fmt.Sprintf("%d d%v@%v", r.pulse, k.midi, k.velocity))
+}
+
+func (r *eventRecorder) keyUp(k keyEvent) {
+	r.events = append(r.events, fmt.Sprintf("%d u%v", r.pulse, k.midi))
+}
+
+func TestArpWaitsForPulse(t *testing.T) {
+	c := newClock(120)
+	defer c.stop()
+	a := newArp("arp", c)
+	a.stop() // so we can drive it
+	r := &eventRecorder{}
+	a.targets[r.ID()] = r
+	a.parse("rate 1/16")
+	a.bpm = 120
+
+	pos := func(n uint64) position {
+		return position{pulse: n, bar: 1, beat: 1, tick: int(n), meter: meter{4, 4}, ppqn: 24}
+	}
+	a.align(pos(0))
+	a.press(keyEvent{60, 1})
+	a.align(pos(0))
+	a.elapse(a.last.Add(time.Second))   // a late pulse
+	if got := len(r.events); got != 1 { // nor the gate, which is after the next pulse
+		t.Errorf("expected the step to wait for its pulse, got %v", r.events)
+	}
+	a.align(pos(6))
+	if got := len(r.events); got != 3 {
+		t.Errorf("expected the step on its pulse, got %v", r.events)
+	}
+}
+
+// stalledRecorder is a keyRecorder that doesn't take keys until let go.
+type stalledRecorder struct {
+	keyRecorder
+	stalled chan struct{}
+}
+
+func (r *stalledRecorder) keyDown(k keyEvent) {
+	<-r.stalled
+	r.keyRecorder.keyDown(k)
+}
+
+func (r *stalledRecorder) keyUp(k keyEvent) {
+	<-r.stalled
+	r.keyRecorder.keyUp(k)
+}
+
+func TestKeyQueueStalledTarget(t *testing.T) {
+	r := &stalledRecorder{stalled: make(chan struct{})}
+	q := newKeyQueue(r)
+	played := make(chan struct{})
+	go func() {
+		for i := 0; i < 2*keyQueueSize; i++ {
+			q.keyDown(keyEvent{60, 1})
+			q.keyUp(keyEvent{60, 1})
+		}
+		close(played)
+	}()
+	select {
+	case <-played:
+	case <-time.After(time.Second):
+		t.Fatal("held up by a stalled target")
+	}
+
+	close(r.stalled)
+	for deadline := time.Now().Add(time.Second); len(q.c) > 0 && time.Now().Before(deadline); {
+		time.Sleep(time.Millisecond)
+	}
+	q.keyDown(keyEvent{64, 1}) // after a reset
+	closeTarget(q)
+	deadline := time.Now().Add(time.Second)
+	for len(r.played()) <= 0 || r.played()[len(r.played())-1].midi != 64 {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected 64 played last, got %v", r.played())
+		}
+		time.Sleep(time.Millisecond)
+	}
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	if n := len(r.ups); n <= 0 || r.ups[n-1].midi != 0 {
+		t.Errorf("expected a reset after dropping keys, got %v", r.ups)
+	}
+}
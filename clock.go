@@ -1,23 +1,57 @@
 package main
 
 import (
+	"fmt"
 	"log"
+	"math"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/peterbourgon/field"
 )
 
+// tickReceiver is told each pulse of the clock, and where it falls.
 type tickReceiver interface {
 	identifier
-	tick(uint64)
+	tick(position)
 }
 
+// tempoReceiver is a tickReceiver that also wants to know the tempo. It's
+// told when it subscribes, and whenever the tempo changes.
+type tempoReceiver interface {
+	tickReceiver
+	tempo(bpm float32)
+}
+
+// clock ticks at a resolution of some pulses per quarter note (ppqn), like
+// MIDI clock's 24. Finer resolutions let commands land on smaller subdivisions,
+// and reduce the timing error of the ones that do.
+//
+// It counts bars in a meter, like 7/8, that can be changed as it goes; the
+// change takes effect from the next bar.
+//
+// Pulses can be played early or late, with swing, or a groove template loaded
+// from a file. Either can be changed live, and take effect from the next
+// pulse. A groove, if one's selected, replaces swing.
+//
+// The tempo can jump, or ramp smoothly over some bars or beats, and follow a
+// tempo map of changes at the start of given bars.
 type clock struct {
-	subs map[string]tickReceiver
+	subs   map[string]tickReceiver
+	params *params
+
+	mtx         sync.Mutex
+	pos         position // of the last pulse
+	grooves     map[string]*groove
+	grooveNames []string // in the order loaded, for the groove param
+	tempos      tempoMap
 
-	newBPM          chan float32
+	newBPM          chan float32 // with room for one, so setting never blocks
+	changes         chan tempoChange
+	mapped          chan int      // bars the tempo map's just been given changes for
+	newPPQN         chan struct{} // with room for one, like newBPM
 	subscriptions   chan tickReceiver
 	unsubscriptions chan tickReceiver
 	quit            chan chan struct{}
@@ -25,51 +59,180 @@ type clock struct {
 
 func newClock(bpm float32) *clock {
 	c := &clock{
-		subs: map[string]tickReceiver{},
+		subs:        map[string]tickReceiver{},
+		pos:         position{bar: 1, beat: 1, meter: meter{4, 4}, ppqn: 24},
+		grooves:     map[string]*groove{},
+		grooveNames: []string{"off"},
+		tempos:      tempoMap{},
 
-		newBPM:          make(chan float32),
+		newBPM:          make(chan float32, 1),
+		changes:         make(chan tempoChange),
+		mapped:          make(chan int),
+		newPPQN:         make(chan struct{}, 1),
 		subscriptions:   make(chan tickReceiver),
 		unsubscriptions: make(chan tickReceiver),
 		quit:            make(chan chan struct{}),
 	}
-	go c.loop(bpm)
+	c.params = newParams(
+		floatP("bpm", 1, 999, float64(bpm), ""),
+		enumP("ppqn", "24", "24", "96", "480"),
+		intP("meter.beats", 1, 64, 4),
+		enumP("meter.unit", "4", "1", "2", "4", "8", "16", "32"),
+		floatP("swing", 50, 75, 50, "%"),
+		enumP("swing.unit", "1/16", "1/8", "1/16"),
+		enumFuncP("groove", "off", c.grooveList),
+	)
+	// These may be called from the clock's loop, by a tick receiver modulating
+	// the clock, so they leave the change for the loop to pick up rather than
+	// waiting on it.
+	c.params.changed = func(name string, v float64) {
+		switch name {
+		case "bpm":
+			for {
+				select {
+				case c.newBPM <- float32(v):
+					return
+				default:
+				}
+				select {
+				case <-c.newBPM: // superseded
+				default:
+				}
+			}
+		case "ppqn":
+			select {
+			case c.newPPQN <- struct{}{}:
+			default: // the loop's yet to see the last change, and will see this
+			}
+		}
+	}
+	go c.loop(bpm, c.ppqn())
 	return c
 }
 
-func (c *clock) loop(bpm float32) {
+func (c *clock) loop(bpm float32, ppqn int) {
 	log.Printf("clock: started")
 	defer log.Printf("clock: done")
 
-	t, n := time.NewTicker(bpm2duration(bpm)), uint64(0)
+	// Each pulse is due when it would be on a straight grid, shifted by any
+	// groove. The grid is kept in absolute time, so timing errors don't add
+	// up; grid is where on it the next pulse is.
+	var (
+		n       = uint64(0) // the next pulse
+		b       = bars{meter: c.meter(), bar: 1}
+		pos     = b.position(n, ppqn, c.meter)
+		grid    = time.Now().Add(pulseDuration(bpm, ppqn))
+		t       = time.NewTimer(time.Until(c.due(grid, pos, bpm)))
+		ramping *tempoRamp // if the tempo's changing
+	)
+
+	// setTempo changes the tempo from the next pulse on, which is at grid
+	// as the last tempo had it.
+	setTempo := func(newBPM float32) {
+		bpm = newBPM
+		c.params.report("bpm", float64(bpm))
+		for _, sub := range c.subs {
+			if r, ok := sub.(tempoReceiver); ok {
+				r.tempo(bpm)
+			}
+		}
+	}
+
+	// change starts a tempo change from the next pulse.
+	change := func(tc tempoChange) {
+		if tc.over <= 0 {
+			ramping = nil
+			setTempo(float32(tc.bpm))
+			return
+		}
+		ramping = &tempoRamp{from: float64(bpm), to: tc.bpm, start: n, end: n + tc.pulses(pos)}
+	}
+	if tc, ok := c.tempoAt(pos.bar); ok {
+		log.Printf("clock: bar %d: %s", pos.bar, tc)
+		change(tc)
+	}
+
 	for {
 		select {
 		case <-t.C:
 			//log.Printf("clock: ⦿ (%d → %d)", n, len(c.subs))
+			c.mtx.Lock()
+			c.pos = pos
+			c.mtx.Unlock()
 			for _, sub := range c.subs {
-				sub.tick(n)
+				sub.tick(pos)
 			}
 			n++
+			if ramping != nil {
+				grid = grid.Add(ramping.duration(n-1, ppqn))
+				setTempo(float32(ramping.bpm(n)))
+				if n >= ramping.end {
+					log.Printf("clock: %.2f", bpm)
+					ramping = nil
+				}
+			} else {
+				grid = grid.Add(pulseDuration(bpm, ppqn))
+			}
+			pos = b.position(n, ppqn, c.meter)
+			if pos.downbeat() {
+				if tc, ok := c.tempoAt(pos.bar); ok {
+					log.Printf("clock: bar %d: %s", pos.bar, tc)
+					change(tc)
+				}
+			}
+			t.Reset(time.Until(c.due(grid, pos, bpm)))
 
-		case bpm := <-c.newBPM:
-			log.Printf("clock: %.2f", bpm)
-			t.Stop()
-			t = time.NewTicker(bpm2duration(bpm))
+		case newBPM := <-c.newBPM:
+			log.Printf("clock: %.2f", newBPM)
+			ramping = nil
+			grid = grid.Add(pulseDuration(newBPM, ppqn) - pulseDuration(bpm, ppqn))
+			setTempo(newBPM)
+			resetTimer(t, time.Until(c.due(grid, pos, bpm)))
+
+		case tc := <-c.changes:
+			log.Printf("clock: %s", tc)
+			change(tc)
+
+		case bar := <-c.mapped:
+			if tc, ok := c.tempoAt(bar); ok && bar == pos.bar {
+				log.Printf("clock: bar %d: %s", pos.bar, tc) // its downbeat's gone by
+				change(tc)
+			}
+
+		case <-c.newPPQN:
+			newPPQN := c.ppqn()
+			if newPPQN == ppqn {
+				continue
+			}
+			log.Printf("clock: %d ppqn", newPPQN)
+			if ramping != nil {
+				ramping.rescale(ppqn, newPPQN)
+			}
+			n = n * uint64(newPPQN) / uint64(ppqn) // same place in the beat, and time
+			b.start = b.start * uint64(newPPQN) / uint64(ppqn)
+			ppqn = newPPQN
+			pos = b.position(n, ppqn, c.meter)
+			resetTimer(t, time.Until(c.due(grid, pos, bpm)))
 
 		case r := <-c.subscriptions:
 			if _, ok := c.subs[r.ID()]; ok {
 				log.Printf("clock: double-subscribe %s", r.ID())
-				return
+				continue
 			}
 			c.subs[r.ID()] = r
+			if r, ok := r.(tempoReceiver); ok {
+				r.tempo(bpm)
+			}
 
 		case r := <-c.unsubscriptions:
-			if _, ok := c.subs[r.ID()]; !ok {
+			if sub, ok := c.subs[r.ID()]; !ok || sub != r {
 				log.Printf("clock: %s not found to unsubscribe", r.ID())
-				return
+				continue
 			}
 			delete(c.subs, r.ID())
 
 		case q := <-c.quit:
+			t.Stop()
 			close(q)
 			return
 		}
@@ -85,6 +248,7 @@ func (c *clock) unsubscribe(r tickReceiver) {
 }
 
 func (c *clock) parse(input string) {
+	raw := strings.Split(strings.TrimSpace(input), " ") // for file names
 	input = strings.TrimSpace(strings.ToLower(input))
 	toks := strings.Split(input, " ")
 	if len(toks) <= 0 {
@@ -92,21 +256,276 @@ func (c *clock) parse(input string) {
 		return
 	}
 
-	switch toks[0] {
-	case "bpm":
-		if len(toks) != 2 {
-			log.Printf("clock: %s: bad args", input)
+	if toks[0] == "bpm" && len(toks) > 2 {
+		tc, err := parseTempoChange(toks[1:])
+		if err != nil {
+			log.Printf("clock: %s: %s", input, err)
 			return
 		}
-		bpm, err := strconv.ParseFloat(toks[1], 32)
+		c.changes <- tc
+		return
+	}
+
+	if toks[0] == "tempo" {
+		c.parseTempoMap(input, toks[1:])
+		return
+	}
+
+	if len(toks) >= 2 && toks[0] == "groove" && toks[1] == "load" {
+		if len(toks) < 3 {
+			log.Printf("clock: %s: want groove load <file>", input)
+			return
+		}
+		filename := strings.Join(raw[2:], " ")
+		g, err := loadGroove(filename)
 		if err != nil {
 			log.Printf("clock: %s: %s", input, err)
 			return
 		}
-		c.newBPM <- float32(bpm)
+		name := grooveName(filename)
+		if name == "off" {
+			log.Printf("clock: %s: can't call a groove off", input)
+			return
+		}
+		c.loadGroove(name, g)
+		log.Printf("clock: loaded groove %s (%d steps)", name, len(g.timing))
+		return
+	}
 
-	default:
+	if toks[0] == "meter" {
+		if len(toks) < 2 {
+			log.Printf("clock: %s: want meter <beats>/<unit>", input)
+			return
+		}
+		m, err := parseMeter(toks[1])
+		if err != nil {
+			log.Printf("clock: %s: %s", input, err)
+			return
+		}
+		setParam(c, []string{"meter.beats", strconv.Itoa(m.beats)})
+		setParam(c, []string{"meter.unit", strconv.Itoa(m.unit)})
+		return
+	}
+
+	if !c.params.has(toks[0]) {
 		log.Printf("clock: %s: aroo", input)
+		return
+	}
+	setParam(c, toks)
+}
+
+// parseTempoMap takes
+//
+//	tempo at <bar> <bpm> [over <n> bars|beats]
+//	tempo remove <bar>
+//	tempo clear
+//	tempo list
+func (c *clock) parseTempoMap(input string, toks []string) {
+	if len(toks) <= 0 {
+		log.Printf("clock: %s: want at, remove, clear or list", input)
+		return
+	}
+	if toks[0] == "at" {
+		if len(toks) < 3 {
+			log.Printf("clock: %s: want tempo at <bar> <bpm> [over <n> bars|beats]", input)
+			return
+		}
+		bar, err := strconv.Atoi(toks[1])
+		if err != nil || bar < 1 {
+			log.Printf("clock: %s: %s: want a bar from 1", input, toks[1])
+			return
+		}
+		tc, err := parseTempoChange(toks[2:])
+		if err != nil {
+			log.Printf("clock: %s: %s", input, err)
+			return
+		}
+		c.mtx.Lock()
+		c.tempos[bar] = tc
+		c.mtx.Unlock()
+		c.mapped <- bar // so it starts now, if it's for this bar
+		return
+	}
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	switch toks[0] {
+	case "remove":
+		bar, err := strconv.Atoi(strings.Join(toks[1:], " "))
+		if _, ok := c.tempos[bar]; err != nil || !ok {
+			log.Printf("clock: %s: no such change", input)
+			return
+		}
+		delete(c.tempos, bar)
+
+	case "clear":
+		c.tempos = tempoMap{}
+
+	case "list":
+		for _, line := range c.tempos.describe() {
+			log.Printf("clock: %s", line)
+		}
+
+	default:
+		log.Printf("clock: %s: want at, remove, clear or list", input)
+	}
+}
+
+// tempoAt is the tempo map's change at the start of a bar, if any.
+func (c *clock) tempoAt(bar int) (tempoChange, bool) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	tc, ok := c.tempos[bar]
+	return tc, ok
+}
+
+func (c *clock) parameters() *params { return c.params }
+
+// ppqn is the clock's resolution, in pulses per quarter note, or beat.
+func (c *clock) ppqn() int {
+	n, _ := strconv.Atoi(c.params.enum("ppqn"))
+	return n
+}
+
+// due is when the pulse at pos plays, with any groove, if it's at grid when
+// played straight.
+func (c *clock) due(grid time.Time, pos position, bpm float32) time.Time {
+	g := c.groove()
+	if g == nil {
+		return grid
+	}
+	shift := g.shift(pos.inBar(), pos.meter.barPulses(pos.ppqn), pos.ppqn)
+	return grid.Add(time.Duration(shift * float64(pulseDuration(bpm, pos.ppqn))))
+}
+
+// groove is the groove that's selected, or swing, or nil to play straight.
+func (c *clock) groove() *groove {
+	if name := c.params.enum("groove"); name != "off" {
+		c.mtx.Lock()
+		defer c.mtx.Unlock()
+		return c.grooves[name]
+	}
+	if swing := c.params.float("swing"); swing > 50 {
+		unit, _ := parseNoteValue(c.params.enum("swing.unit"))
+		return swingGroove(swing, unit)
+	}
+	return nil
+}
+
+// accent is the groove's velocity offset for a note played at pos.
+func (c *clock) accent(pos position) float64 {
+	g := c.groove()
+	if g == nil {
+		return 0
+	}
+	return g.accent(pos.inBar(), pos.meter.barPulses(pos.ppqn), pos.ppqn)
+}
+
+// loadGroove loads a groove template, or replaces one of the same name.
+func (c *clock) loadGroove(name string, g *groove) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if _, ok := c.grooves[name]; !ok {
+		c.grooveNames = append(c.grooveNames, name)
+	}
+	c.grooves[name] = g
+}
+
+// grooveList is the options of the groove param: off, and those loaded.
+func (c *clock) grooveList() []string {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return append([]string{}, c.grooveNames...)
+}
+
+// meter is the meter as set, which isn't the current bar's until the next.
+func (c *clock) meter() meter {
+	unit, _ := strconv.Atoi(c.params.enum("meter.unit"))
+	return meter{c.params.int("meter.beats"), unit}
+}
+
+// position is where the clock is, as of its last pulse.
+func (c *clock) position() position {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return c.pos
+}
+
+// meter is a time signature: beats to the bar, each a unit note long, so 7/8
+// is seven eighth notes.
+type meter struct{ beats, unit int }
+
+// parseMeter parses a meter like 7/8. The unit must be a power of two that
+// the coarsest resolution can count.
+func parseMeter(s string) (meter, error) {
+	i := strings.Index(s, "/")
+	if i < 0 {
+		return meter{}, fmt.Errorf("%s: want <beats>/<unit>", s)
+	}
+	beats, err := strconv.Atoi(s[:i])
+	if err != nil || beats < 1 || beats > 64 {
+		return meter{}, fmt.Errorf("%s: want 1..64 beats", s)
+	}
+	unit, err := strconv.Atoi(s[i+1:])
+	if err != nil || unit < 1 || unit > 32 || unit&(unit-1) != 0 {
+		return meter{}, fmt.Errorf("%s: want a unit of 1, 2, 4, 8, 16 or 32", s)
+	}
+	return meter{beats, unit}, nil
+}
+
+func (m meter) String() string { return fmt.Sprintf("%d/%d", m.beats, m.unit) }
+
+func (m meter) beatPulses(ppqn int) uint64 { return uint64(4 * ppqn / m.unit) }
+func (m meter) barPulses(ppqn int) uint64  { return uint64(m.beats) * m.beatPulses(ppqn) }
+
+// position is a musical position: the bar and beat, counted from 1, and the
+// pulse within the beat, counted from 0. Beats are of the meter's unit.
+type position struct {
+	pulse uint64 // since the clock started
+	bar   int
+	beat  int
+	tick  int
+	meter meter
+	ppqn  int
+}
+
+func (p position) String() string { return fmt.Sprintf("%d %d %d", p.bar, p.beat, p.tick) }
+
+// beats is how many beats, or quarter notes, the clock has counted.
+func (p position) beats() float64 { return float64(p.pulse) / float64(p.ppqn) }
+
+// inBar is how many pulses into the bar the position is.
+func (p position) inBar() uint64 {
+	return uint64(p.beat-1)*p.meter.beatPulses(p.ppqn) + uint64(p.tick)
+}
+
+// downbeat is whether the position is the first pulse of a bar.
+func (p position) downbeat() bool { return p.beat == 1 && p.tick == 0 }
+
+// bars counts bars as pulses go by.
+type bars struct {
+	meter meter  // of the current bar
+	bar   int    // from 1
+	start uint64 // the pulse the current bar started on
+}
+
+// position finds where pulse n falls, moving on as many bars as that takes,
+// and asking next for the meter of each new one. n mustn't be before the
+// current bar.
+func (b *bars) position(n uint64, ppqn int, next func() meter) position {
+	for n-b.start >= b.meter.barPulses(ppqn) {
+		b.start += b.meter.barPulses(ppqn)
+		b.bar++
+		b.meter = next()
+	}
+	off, beat := n-b.start, b.meter.beatPulses(ppqn)
+	return position{
+		pulse: n,
+		bar:   b.bar,
+		beat:  int(off/beat) + 1,
+		tick:  int(off % beat),
+		meter: b.meter,
+		ppqn:  ppqn,
 	}
 }
 
@@ -122,6 +541,93 @@ func (c *clock) Connection(field.Node) error { return errNo }
 func (c *clock) Disconnect(field.Node)       {}
 func (c *clock) Disconnection(field.Node)    {}
 
+// resetTimer changes when a timer fires, whether or not it already has.
+func resetTimer(t *time.Timer, d time.Duration) {
+	if !t.Stop() {
+		select {
+		case <-t.C:
+		default:
+		}
+	}
+	t.Reset(d)
+}
+
 func bpm2duration(bpm float32) time.Duration {
 	return time.Duration((60.0 / bpm) * float32(time.Second))
 }
+
+// pulseDuration is the time between ticks at a tempo and resolution.
+func pulseDuration(bpm float32, ppqn int) time.Duration {
+	return bpm2duration(bpm) / time.Duration(ppqn)
+}
+
+// noteNames are note values by name, in beats.
+var noteNames = map[string]float64{
+	"whole":     4,
+	"half":      2,
+	"beat":      1,
+	"quarter":   1,
+	"eighth":    0.5,
+	"8th":       0.5,
+	"sixteenth": 0.25,
+	"16th":      0.25,
+	"32nd":      0.125,
+}
+
+// parseNoteValue parses a note value like 1/4 (a quarter note), 1/8. (dotted
+// eighth), 1/8t (eighth triplet) or 2 (two whole notes), and returns its
+// length in beats, where a beat is a quarter note. Note values can be named,
+// like beat, 8th or 16th., as well.
+func parseNoteValue(s string) (float64, error) {
+	if beats, ok := noteNames[s]; ok {
+		return beats, nil
+	}
+	mult := 1.0
+	switch {
+	case strings.HasSuffix(s, "."):
+		s, mult = strings.TrimSuffix(s, "."), 1.5
+	case strings.HasSuffix(s, "t"):
+		s, mult = strings.TrimSuffix(s, "t"), 2.0/3.0
+	}
+	if beats, ok := noteNames[s]; ok {
+		return mult * beats, nil
+	}
+
+	num, den := s, "1"
+	if i := strings.Index(s, "/"); i >= 0 {
+		num, den = s[:i], s[i+1:]
+	}
+	n, err := strconv.ParseFloat(num, 64)
+	if err != nil {
+		return 0, err
+	}
+	d, err := strconv.ParseFloat(den, 64)
+	if err != nil {
+		return 0, err
+	}
+	if n <= 0 || d <= 0 {
+		return 0, fmt.Errorf("%s: bad note value", s)
+	}
+	return 4 * mult * n / d, nil
+}
+
+// parsePulses parses a number of pulses, or a note value at a resolution of
+// ppqn, which must come out to a whole number of pulses. A plain number is
+// pulses, not whole notes.
+func parsePulses(s string, ppqn int) (uint64, error) {
+	if n, err := strconv.ParseUint(s, 10, 64); err == nil {
+		if n <= 0 {
+			return 0, fmt.Errorf("%s: need at least one pulse", s)
+		}
+		return n, nil
+	}
+	beats, err := parseNoteValue(s)
+	if err != nil {
+		return 0, err
+	}
+	n := beats * float64(ppqn)
+	if r := math.Floor(n + 0.5); r < 1 || math.Abs(n-r) > 1e-9 {
+		return 0, fmt.Errorf("%s: not a whole number of pulses at %d ppqn", s, ppqn)
+	}
+	return uint64(math.Floor(n + 0.5)), nil
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParseNoteValue(t *testing.T) {
+	for _, c := range []struct {
+		input    string
+		expected float64 // beats
+	}{
+		{"1/4", 1},
+		{"1/8.", 0.75},
+		{"1/8t", 1.0 / 3},
+		{"2", 8},
+		{"beat", 1},
+		{"8th", 0.5},
+		{"16th.", 0.375},
+		{"quartert", 2.0 / 3},
+		{"whole", 4},
+	} {
+		got, err := parseNoteValue(c.input)
+		if err != nil {
+			t.Errorf("%s: %s", c.input, err)
+			continue
+		}
+		if got != c.expected {
+			t.Errorf("%s: expected %v beats, got %v", c.input, c.expected, got)
+		}
+	}
+}
+
+func TestParsePulses(t *testing.T) {
+	for _, c := range []struct {
+		input    string
+		ppqn     int
+		expected uint64 // 0 for an error
+	}{
+		{"6", 24, 6},
+		{"0", 24, 0},
+		{"beat", 24, 24},
+		{"16th", 24, 6},
+		{"1/8t", 24, 8},
+		{"1/16t", 96, 16},
+		{"1/64", 24, 0},
+		{"1/64", 96, 6},
+		{"1/128t", 480, 10},
+		{"whole", 480, 1920},
+		{"fortnight", 24, 0},
+	} {
+		got, err := parsePulses(c.input, c.ppqn)
+		if c.expected == 0 {
+			if err == nil {
+				t.Errorf("%s at %d ppqn: expected an error, got %d", c.input, c.ppqn, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s at %d ppqn: %s", c.input, c.ppqn, err)
+			continue
+		}
+		if got != c.expected {
+			t.Errorf("%s at %d ppqn: expected %d, got %d", c.input, c.ppqn, c.expected, got)
+		}
+	}
+}
+
+func TestClockPPQN(t *testing.T) {
+	c := newClock(120)
+	defer c.stop()
+	for _, cmd := range []struct {
+		input    string
+		expected int
+	}{
+		{"ppqn 96", 96},
+		{"ppqn 480", 480},
+		{"ppqn 48", 480}, // not an option
+		{"ppqn 24", 24},
+	} {
+		c.parse(cmd.input)
+		if got := c.ppqn(); got != cmd.expected {
+			t.Errorf("%s: expected %d, got %d", cmd.input, cmd.expected, got)
+		}
+	}
+	if got := (position{pulse: 36, ppqn: 24}).beats(); got != 1.5 {
+		t.Errorf("36 pulses at 24 ppqn: expected 1.5 beats, got %v", got)
+	}
+}
+
+func TestParseMeter(t *testing.T) {
+	for _, c := range []struct {
+		input    string
+		expected meter // zero for an error
+	}{
+		{"4/4", meter{4, 4}},
+		{"7/8", meter{7, 8}},
+		{"15/16", meter{15, 16}},
+		{"3/3", meter{}},
+		{"0/4", meter{}},
+		{"4/64", meter{}},
+		{"4", meter{}},
+	} {
+		got, err := parseMeter(c.input)
+		if c.expected == (meter{}) {
+			if err == nil {
+				t.Errorf("%s: expected an error, got %s", c.input, got)
+			}
+			continue
+		}
+		if err != nil || got != c.expected {
+			t.Errorf("%s: expected %s, got %s (%v)", c.input, c.expected, got, err)
+		}
+	}
+}
+
+func TestBarsPosition(t *testing.T) {
+	// 24 ppqn: a bar of 7/8 is 84 pulses, then 4/4 from bar 2 is 96.
+	b := bars{meter: meter{7, 8}, bar: 1}
+	next := func() meter { return meter{4, 4} }
+	for _, c := range []struct {
+		pulse           uint64
+		bar, beat, tick int
+		expectedMeter   meter
+	}{
+		{0, 1, 1, 0, meter{7, 8}},
+		{11, 1, 1, 11, meter{7, 8}},
+		{12, 1, 2, 0, meter{7, 8}},
+		{83, 1, 7, 11, meter{7, 8}},
+		{84, 2, 1, 0, meter{4, 4}},
+		{84 + 95, 2, 4, 23, meter{4, 4}},
+		{84 + 96 + 30, 3, 2, 6, meter{4, 4}},
+	} {
+		got := b.position(c.pulse, 24, next)
+		if got.bar != c.bar || got.beat != c.beat || got.tick != c.tick || got.meter != c.expectedMeter {
+			t.Errorf("pulse %d: expected %d %d %d in %s, got %s in %s", c.pulse, c.bar, c.beat, c.tick, c.expectedMeter, got, got.meter)
+		}
+	}
+}
+
+func TestTempoMapFirstBar(t *testing.T) {
+	c := newClock(120)
+	defer c.stop()
+	c.parse("tempo at 1 90")
+	for deadline := time.Now().Add(time.Second); c.params.float("bpm") != 90 && time.Now().Before(deadline); {
+		time.Sleep(time.Millisecond)
+	}
+	if got := c.params.float("bpm"); got != 90 {
+		t.Errorf("tempo at 1 90: expected 90 bpm in bar 1, got %v", got)
+	}
+	c.parse("tempo at 2 100")
+	time.Sleep(10 * time.Millisecond)
+	if got := c.params.float("bpm"); got != 90 {
+		t.Errorf("tempo at 2 100: expected it to wait for bar 2, got %v", got)
+	}
+}
+
+// bpmSetter sets its clock's tempo from the clock's own loop.
+type bpmSetter struct {
+	c    *clock
+	once sync.Once
+}
+
+func (s *bpmSetter) ID() string { return "bpmsetter" }
+
+func (s *bpmSetter) tick(position) {
+	s.once.Do(func() {
+		s.c.params.set("bpm", "150")
+		s.c.params.set("ppqn", "96")
+	})
+}
+
+func TestClockSetFromTick(t *testing.T) {
+	c := newClock(999)
+	c.subscribe(&bpmSetter{c: c})
+	stopped := make(chan struct{})
+	go func() {
+		for deadline := time.Now().Add(time.Second); (c.params.float("bpm") != 150 || c.ppqn() != 96) && time.Now().Before(deadline); {
+			time.Sleep(time.Millisecond)
+		}
+		c.stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(2 * time.Second):
+		t.Fatal("clock deadlocked setting its own params")
+	}
+	if got := c.params.float("bpm"); got != 150 {
+		t.Errorf("expected 150 bpm, got %v", got)
+	}
+	if got := c.ppqn(); got != 96 {
+		t.Errorf("expected 96 ppqn, got %d", got)
+	}
+}
@@ -1,29 +1,33 @@
 package main
 
 import (
+	"fmt"
 	"log"
+	"strconv"
+	"strings"
 )
 
 // commandBuffer collects commands to be executed later. Commands are enqueued
-// with the queue method, and supply a modulo when they should be executed. The
-// commandBuffer releases the commands to the parser when an incoming tick
-// modulos 0 against the respective commands.
+// with the queue method, and supply a schedule when they should be executed.
+// The commandBuffer releases the commands to the parser when an incoming tick
+// falls on their schedule: a modulo of the clock's pulses, so with 24 ppqn, a
+// modulo of 6 is the next 16th note, or a modulo of bars, on the downbeat.
 type commandBuffer struct {
 	parser parser
-	buffer map[uint64][]string
+	buffer map[schedule][]string
 
 	requests chan queueRequest
-	ticks    chan uint64
+	ticks    chan position
 	quit     chan chan struct{}
 }
 
 func newCommandBuffer(c *clock, p parser) *commandBuffer {
 	b := &commandBuffer{
 		parser: p,
-		buffer: map[uint64][]string{},
+		buffer: map[schedule][]string{},
 
 		requests: make(chan queueRequest),
-		ticks:    make(chan uint64),
+		ticks:    make(chan position),
 		quit:     make(chan chan struct{}),
 	}
 	go b.loop(c)
@@ -40,11 +44,11 @@ func (b *commandBuffer) loop(c *clock) {
 	for {
 		select {
 		case req := <-b.requests:
-			b.buffer[req.modulo] = append(b.buffer[req.modulo], req.command)
+			b.buffer[req.schedule] = append(b.buffer[req.schedule], req.command)
 
-		case tick := <-b.ticks:
-			//log.Printf("cmdbuf: tick %d pending %d", tick, len(b.buffer))
-			b.buffer = fwd(b.parser, b.buffer, tick)
+		case pos := <-b.ticks:
+			//log.Printf("cmdbuf: tick %d pending %d", pos.pulse, len(b.buffer))
+			b.buffer = fwd(b.parser, b.buffer, pos)
 
 		case q := <-b.quit:
 			close(q)
@@ -55,12 +59,12 @@ func (b *commandBuffer) loop(c *clock) {
 
 func (b *commandBuffer) ID() string { return "cmdbuf" }
 
-func (b *commandBuffer) tick(tick uint64) {
-	b.ticks <- tick
+func (b *commandBuffer) tick(pos position) {
+	b.ticks <- pos
 }
 
-func (b *commandBuffer) queue(modulo uint64, command string) {
-	b.requests <- queueRequest{modulo, command}
+func (b *commandBuffer) queue(s schedule, command string) {
+	b.requests <- queueRequest{s, command}
 }
 
 func (b *commandBuffer) stop() {
@@ -70,17 +74,71 @@ func (b *commandBuffer) stop() {
 }
 
 type queueRequest struct {
-	modulo  uint64
-	command string
+	schedule schedule
+	command  string
 }
 
-func fwd(p parser, buffer map[uint64][]string, tick uint64) map[uint64][]string {
-	toParse, survivors := []string{}, map[uint64][]string{}
-	for modulo, commands := range buffer {
-		if tick%modulo == 0 {
+// schedule is when a command runs: on the next pulse that's a multiple of
+// modulo, or if bars, the next downbeat of a bar that is.
+//
+//	% <beats|note value|bars> <command>
+//	%p <pulses> <command>
+type schedule struct {
+	modulo uint64
+	bars   bool
+}
+
+// parseSchedule parses a number of beats (quarter notes), a note value as
+// parsePulses does, or bars, like bar or 4bars. A plain number is beats, as
+// it's always been; use parsePulses for pulses.
+func parseSchedule(s string, ppqn int) (schedule, error) {
+	if n, err := strconv.ParseUint(s, 10, 64); err == nil {
+		if n <= 0 {
+			return schedule{}, fmt.Errorf("%s: need at least one beat", s)
+		}
+		return schedule{modulo: n * uint64(ppqn)}, nil
+	}
+	for _, suffix := range []string{"bars", "bar"} {
+		if !strings.HasSuffix(s, suffix) {
+			continue
+		}
+		n, err := uint64(1), error(nil)
+		if num := strings.TrimSuffix(s, suffix); num != "" {
+			n, err = strconv.ParseUint(num, 10, 64)
+		}
+		if err != nil || n <= 0 {
+			return schedule{}, fmt.Errorf("%s: want a number of bars", s)
+		}
+		return schedule{modulo: n, bars: true}, nil
+	}
+	n, err := parsePulses(s, ppqn)
+	if err != nil {
+		return schedule{}, err
+	}
+	return schedule{modulo: n}, nil
+}
+
+func (s schedule) String() string {
+	if s.bars {
+		return fmt.Sprintf("%d bars", s.modulo)
+	}
+	return fmt.Sprintf("%d pulses", s.modulo)
+}
+
+func (s schedule) due(pos position) bool {
+	if s.bars {
+		return pos.downbeat() && uint64(pos.bar-1)%s.modulo == 0
+	}
+	return pos.pulse%s.modulo == 0
+}
+
+func fwd(p parser, buffer map[schedule][]string, pos position) map[schedule][]string {
+	toParse, survivors := []string{}, map[schedule][]string{}
+	for s, commands := range buffer {
+		if s.due(pos) {
 			toParse = append(toParse, commands...)
 		} else {
-			survivors[modulo] = commands
+			survivors[s] = commands
 		}
 	}
 
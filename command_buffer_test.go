@@ -0,0 +1,64 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+type parseRecorder []string
+
+func (r *parseRecorder) parse(input string) { *r = append(*r, input) }
+
+func TestParseSchedule(t *testing.T) {
+	for _, c := range []struct {
+		input    string
+		expected schedule // zero for an error
+	}{
+		{"6", schedule{modulo: 6 * 24}}, // beats
+		{"0", schedule{}},
+		{"16th", schedule{modulo: 6}},
+		{"bar", schedule{modulo: 1, bars: true}},
+		{"4bars", schedule{modulo: 4, bars: true}},
+		{"2bar", schedule{modulo: 2, bars: true}},
+		{"0bars", schedule{}},
+		{"xbars", schedule{}},
+	} {
+		got, err := parseSchedule(c.input, 24)
+		if c.expected == (schedule{}) {
+			if err == nil {
+				t.Errorf("%s: expected an error, got %s", c.input, got)
+			}
+			continue
+		}
+		if err != nil || got != c.expected {
+			t.Errorf("%s: expected %s, got %s (%v)", c.input, c.expected, got, err)
+		}
+	}
+}
+
+func TestFwd(t *testing.T) {
+	buffer := map[schedule][]string{
+		{modulo: 6}:             {"sixteenth"},
+		{modulo: 1, bars: true}: {"bar"},
+		{modulo: 2, bars: true}: {"two bars"},
+	}
+	for _, c := range []struct {
+		pos      position
+		expected []string
+	}{
+		{position{pulse: 85, bar: 2, beat: 1, tick: 1}, []string{}},
+		{position{pulse: 90, bar: 2, beat: 1, tick: 6}, []string{"sixteenth"}},
+		{position{pulse: 96, bar: 2, beat: 2, tick: 0}, []string{}},
+		{position{pulse: 169, bar: 3, beat: 1, tick: 0}, []string{"bar", "two bars"}},
+	} {
+		r := &parseRecorder{}
+		buffer = fwd(r, buffer, c.pos)
+		got := []string(*r)
+		if len(got) == 2 && got[0] > got[1] {
+			got[0], got[1] = got[1], got[0] // map order
+		}
+		if !reflect.DeepEqual(got, c.expected) {
+			t.Errorf("%s: expected %v, got %v", c.pos, c.expected, got)
+		}
+	}
+}
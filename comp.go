@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"strings"
+
+	"github.com/peterbourgon/field"
+)
+
+// comp is a compressor effect. It turns its input down by however much the
+// level goes over threshold, divided by ratio, with a soft knee.
+//
+// By default it listens to its own input. Connect another node to it too,
+// and pick that node with "sidechain <id>", and it listens to that instead:
+// its audio then only drives the gain reduction, and isn't heard. Read how
+// much it's turning down with "get <id> reduction".
+type comp struct {
+	*effect
+	env float64 // gain reduction, in dB, smoothed
+}
+
+func init() {
+	registerNodeType(nodeType{
+		name:        "comp",
+		description: "compressor effect, with a sidechain",
+		args:        []nodeArg{{name: "sidechain", what: "<id>", apply: compSidechainArg}},
+		new:         func(id string, p *platform) field.Node { return newComp(id) },
+	})
+}
+
+func compSidechainArg(n field.Node, id string) error {
+	c, ok := n.(*comp)
+	if !ok {
+		return fmt.Errorf("%s isn't a comp", n.ID())
+	}
+	c.sidechain(id)
+	return nil
+}
+
+func newComp(id string) *comp {
+	c := &comp{}
+	c.effect = newEffect(id, newParams(
+		floatP("threshold", -60, 0, -18, "dB"),
+		floatP("ratio", 1, 20, 4, ""),
+		floatP("attack", 0.1, 200, 10, "ms"),
+		floatP("release", 1, 2000, 150, "ms"),
+		floatP("knee", 0, 24, 6, "dB"),
+		floatP("makeup", 0, 24, 0, "dB"),
+		readOnly(floatP("reduction", 0, 96, 0, "dB")),
+	), c)
+	return c
+}
+
+// process is for completeness; the effect prefers processSidechain.
+func (c *comp) process(in []float32) []float32 {
+	return c.processSidechain(in, nil)
+}
+
+func (c *comp) processSidechain(in, side []float32) []float32 {
+	var (
+		threshold = c.params.float("threshold")
+		ratio     = c.params.float("ratio")
+		knee      = c.params.float("knee")
+		makeup    = c.params.float("makeup")
+		attack    = math.Exp(-1 / (c.params.float("attack") / 1000 * sRate))
+		release   = math.Exp(-1 / (c.params.float("release") / 1000 * sRate))
+		detect    = in
+		out       = make([]float32, len(in))
+	)
+	if side != nil {
+		detect = side
+	}
+
+	var peak float64
+	for i, x := range in {
+		level := 20 * math.Log10(math.Abs(float64(detect[i]))+1e-9)
+		r := level - compressedLevel(level, threshold, ratio, knee)
+		coeff := release
+		if r > c.env {
+			coeff = attack
+		}
+		c.env = r + coeff*(c.env-r)
+		out[i] = x * float32(math.Pow(10, (makeup-c.env)/20))
+		peak = math.Max(peak, c.env)
+	}
+	c.params.report("reduction", math.Floor(peak*10+0.5)/10)
+	return out
+}
+
+// compressedLevel is the gain computer: the level, in dB, that a level in dB
+// comes out at.
+func compressedLevel(level, threshold, ratio, knee float64) float64 {
+	over := level - threshold
+	switch {
+	case 2*over < -knee:
+		return level
+	case 2*math.Abs(over) <= knee && knee > 0:
+		return level + (1/ratio-1)*(over+knee/2)*(over+knee/2)/(2*knee)
+	}
+	return threshold + over/ratio
+}
+
+// sidechain picks the connected node to listen to, or off for the input.
+func (c *comp) sidechain(id string) {
+	if id == "off" {
+		id = ""
+	}
+	c.sidechains <- id
+}
+
+func (c *comp) parse(input string) {
+	toks := strings.Split(strings.TrimSpace(strings.ToLower(input)), " ")
+	switch toks[0] {
+	case "sidechain", "sc":
+		if len(toks) != 2 {
+			log.Printf("%s: %s: want sidechain <id> or sidechain off", c.ID(), input)
+			return
+		}
+		c.sidechain(toks[1])
+		log.Printf("%s: %s: OK", c.ID(), input)
+
+	default:
+		c.effect.parse(input)
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestCompressedLevel(t *testing.T) {
+	for _, c := range []struct {
+		level, threshold, ratio, knee float64
+		expected                      float64
+	}{
+		{-30, -20, 4, 0, -30},   // under
+		{-10, -20, 4, 0, -17.5}, // over
+		{0, -20, 1, 0, 0},       // 1:1
+		{-20, -20, 4, 10, -20.9375},
+		{-26, -20, 4, 10, -26},     // under the knee
+		{-14, -20, 4, 10, -18.5},   // over the knee
+		{-20, -20, 20, 0, -20},     // at threshold, hard knee
+		{-15, -20, 20, 10, -19.75}, // top of the knee
+	} {
+		if got := compressedLevel(c.level, c.threshold, c.ratio, c.knee); math.Abs(got-c.expected) > 1e-9 {
+			t.Errorf("%v dB, threshold %v, %v:1, knee %v: expected %v, got %v", c.level, c.threshold, c.ratio, c.knee, c.expected, got)
+		}
+	}
+}
+
+func TestCompSidechain(t *testing.T) {
+	c := newComp("comp")
+	defer c.stop()
+	c.parse("knee 0")
+	c.parse("attack 0.1")
+
+	loud, quiet := make([]float32, bufSz), make([]float32, bufSz)
+	for i := range loud {
+		loud[i], quiet[i] = 1, 0.01
+	}
+
+	// A quiet input, ducked by a loud sidechain.
+	out := c.processSidechain(quiet, loud)
+	if got := out[bufSz-1]; got > 0.01/4 {
+		t.Errorf("expected the input ducked, got %v", got)
+	}
+	if got, _ := c.params.get("reduction"); got != "13.5 dB" {
+		t.Errorf("expected 13.5 dB reduction, got %s", got)
+	}
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"strings"
+	"sync"
+
+	"github.com/peterbourgon/field"
+)
+
+const (
+	maxIR  = 10.0  // seconds of impulse response, after trimming
+	irFade = 0.010 // seconds to fade out a trimmed end
+)
+
+// convolver is a convolution effect: it plays its input through an impulse
+// response loaded from a WAV file, like a recorded room or speaker cabinet.
+//
+// It uses uniformly partitioned FFT convolution, with partitions of bufSz, so
+// it adds no latency beyond the buffer every effect has. The impulse response
+// can be trimmed with start and length.
+type convolver struct {
+	*effect
+
+	mtx    sync.Mutex
+	loaded *sample // as loaded, to be prepared
+
+	ir            *sample        // as prepared
+	start, length float64        // trim, as prepared
+	parts         [][]complex128 // spectra of the IR's partitions
+	fdl           [][]complex128 // spectra of recent input blocks, newest first
+	prev          []float32      // the last input block
+}
+
+func init() {
+	registerNodeType(nodeType{
+		name:        "convolver",
+		aliases:     []string{"conv"},
+		description: "convolution effect, with an impulse response WAV",
+		args:        []nodeArg{{name: "load", what: "<file>", apply: convolverLoadArg}},
+		new:         func(id string, p *platform) field.Node { return newConvolver(id) },
+	})
+}
+
+func convolverLoadArg(n field.Node, filename string) error {
+	c, ok := n.(*convolver)
+	if !ok {
+		return fmt.Errorf("%s isn't a convolver", n.ID())
+	}
+	return c.load(filename)
+}
+
+func newConvolver(id string) *convolver {
+	c := &convolver{prev: make([]float32, bufSz)}
+	c.effect = newEffect(id, newParams(
+		floatP("start", 0, 10000, 0, "ms"),       // trimmed off the front
+		floatP("length", 0, maxIR*1000, 0, "ms"), // 0 = as long as it is
+		floatP("mix", 0, 1, 0.3, ""),
+	), c)
+	return c
+}
+
+func (c *convolver) process(in []float32) []float32 {
+	c.prepare()
+	wet := make([]float32, len(in))
+	if len(c.parts) <= 0 {
+		return mix(in, wet, float32(c.params.float("mix")))
+	}
+
+	// Overlap-save: transform the last two blocks, multiply by each partition
+	// of the IR against the block it lines up with, and keep the second half.
+	n := 2 * bufSz
+	x := make([]complex128, n)
+	for i, v := range c.prev {
+		x[i] = complex(float64(v), 0)
+	}
+	for i, v := range in {
+		x[bufSz+i] = complex(float64(v), 0)
+	}
+	fft(x, false)
+	copy(c.fdl[1:], c.fdl[:len(c.fdl)-1])
+	c.fdl[0] = x
+
+	y := make([]complex128, n)
+	for k, h := range c.parts {
+		if c.fdl[k] == nil {
+			break // not that many blocks in yet
+		}
+		for i := range y {
+			y[i] += c.fdl[k][i] * h[i]
+		}
+	}
+	fft(y, true)
+	for i := range wet {
+		wet[i] = float32(real(y[bufSz+i]))
+	}
+	copy(c.prev, in)
+	return mix(in, wet, float32(c.params.float("mix")))
+}
+
+// prepare partitions and transforms the IR, if it or its trim has changed.
+func (c *convolver) prepare() {
+	c.mtx.Lock()
+	ir := c.loaded
+	c.mtx.Unlock()
+	start, length := c.params.float("start"), c.params.float("length")
+	if ir == nil || (ir == c.ir && start == c.start && length == c.length) {
+		return
+	}
+	c.ir, c.start, c.length = ir, start, length
+
+	h := trimIR(ir.data, start, length)
+	c.parts = nil
+	for p := 0; p < len(h); p += bufSz {
+		x := make([]complex128, 2*bufSz)
+		for i := 0; i < bufSz && p+i < len(h); i++ {
+			x[i] = complex(h[p+i], 0)
+		}
+		fft(x, false)
+		c.parts = append(c.parts, x)
+	}
+
+	fdl := make([][]complex128, len(c.parts))
+	copy(fdl, c.fdl) // keep what's ringing, as far as it goes
+	c.fdl = fdl
+	log.Printf("%s: %d partitions", c.ID(), len(c.parts))
+}
+
+// trimIR cuts an impulse response at sRate down to start and length, in ms,
+// fades out a cut end, and scales it to unit energy, so that rooms of any
+// size come out at about the same loudness.
+func trimIR(data []float32, start, length float64) []float64 {
+	from := int(start / 1000 * sRate)
+	if from > len(data) {
+		from = len(data)
+	}
+	to := len(data)
+	if length > 0 && from+int(length/1000*sRate) < to {
+		to = from + int(length/1000*sRate)
+	}
+	if max := from + int(maxIR*sRate); to > max {
+		to = max
+	}
+
+	h := make([]float64, to-from)
+	for i := range h {
+		h[i] = float64(data[from+i])
+	}
+	if to < len(data) {
+		fade := int(math.Min(irFade*sRate, float64(len(h))))
+		for i := 0; i < fade; i++ {
+			h[len(h)-1-i] *= float64(i) / float64(fade)
+		}
+	}
+
+	var energy float64
+	for _, v := range h {
+		energy += v * v
+	}
+	if energy > 0 {
+		for i := range h {
+			h[i] /= math.Sqrt(energy)
+		}
+	}
+	return h
+}
+
+// load loads an impulse response, to be prepared by the loop.
+func (c *convolver) load(filename string) error {
+	s, err := loadWAV(filename)
+	if err != nil {
+		return err
+	}
+	c.mtx.Lock()
+	c.loaded = s.resample(sRate)
+	c.mtx.Unlock()
+	log.Printf("%s: loaded %s (%.2fs at %v Hz)", c.ID(), filename, float64(len(s.data))/s.rate, s.rate)
+	return nil
+}
+
+func (c *convolver) parse(input string) {
+	raw := strings.Split(strings.TrimSpace(input), " ") // for file names
+	switch strings.ToLower(raw[0]) {
+	case "load":
+		if len(raw) < 2 {
+			log.Printf("%s: %s: need a file", c.ID(), input)
+			return
+		}
+		if err := c.load(strings.Join(raw[1:], " ")); err != nil {
+			log.Printf("%s: load: %s", c.ID(), err)
+		}
+
+	default:
+		c.effect.parse(input)
+	}
+}
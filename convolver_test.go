@@ -0,0 +1,74 @@
+package main
+
+import (
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"os"
+	"testing"
+)
+
+func TestConvolver(t *testing.T) {
+	c := newConvolver("conv")
+	defer c.stop()
+	c.parse("mix 1")
+
+	// An IR over a few partitions, against direct convolution.
+	ir := []float32{}
+	for i := 0; i < 2*bufSz+300; i++ {
+		ir = append(ir, float32(rand.Float64()*2-1)*float32(math.Exp(-float64(i)/1000)))
+	}
+	f, err := ioutil.TempFile("", "ir*.wav")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.Write(wavFile(wavFloat, 1, 32, le(ir)))
+	f.Close()
+	if err := c.load(f.Name()); err != nil {
+		t.Fatal(err)
+	}
+	c.mtx.Lock()
+	h := trimIR(c.loaded.data, 0, 0) // as resampled
+	c.mtx.Unlock()
+
+	input := make([]float32, 5*bufSz)
+	for i := range input {
+		input[i] = float32(rand.Float64()*2 - 1)
+	}
+	output := []float32{}
+	for b := 0; b < len(input); b += bufSz {
+		output = append(output, c.process(append([]float32{}, input[b:b+bufSz]...))...)
+	}
+
+	for i := range output {
+		var expected float64
+		for j := 0; j < len(h) && j <= i; j++ {
+			expected += h[j] * float64(input[i-j])
+		}
+		if math.Abs(float64(output[i])-expected) > 1e-4 {
+			t.Fatalf("sample %d: expected %.5f, got %.5f", i, expected, output[i])
+		}
+	}
+}
+
+func TestTrimIR(t *testing.T) {
+	data := make([]float32, sRate) // a second
+	for i := range data {
+		data[i] = 1
+	}
+	for _, c := range []struct {
+		start, length float64
+		expected      int
+	}{
+		{0, 0, sRate},
+		{500, 0, sRate / 2},
+		{0, 250, sRate / 4},
+		{900, 250, sRate / 10},
+		{2000, 0, 0},
+	} {
+		if got := len(trimIR(data, c.start, c.length)); got != c.expected {
+			t.Errorf("start %v length %v: expected %d samples, got %d", c.start, c.length, c.expected, got)
+		}
+	}
+}
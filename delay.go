@@ -0,0 +1,145 @@
+package main
+
+import (
+	"log"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/peterbourgon/field"
+)
+
+const (
+	maxDelay    = 5.0   // seconds
+	delaySlew   = 0.050 // seconds for the delay time to settle after a change
+	maxFeedback = 0.98
+)
+
+// delay is an echo effect. Its time is in milliseconds, or synced to the
+// clock as a note value, in which case it follows the tempo. Changes to the
+// time glide, like tape, rather than jump.
+//
+// It echoes in stereo: in ping-pong mode, repeats alternate between the left
+// and right sides. The output is mono for now, so the sides are summed, and
+// ping-pong sounds like a plain delay until there's stereo to hear it in.
+type delay struct {
+	*effect
+	clock *clock
+	lines [2]*modLine // left and right
+	delay float64     // current delay in samples, gliding to the target
+	damp  [2]float32  // state of the damping filters
+}
+
+func init() {
+	registerNodeType(nodeType{
+		name:        "delay",
+		description: "echo effect, in ms or synced to the clock",
+		new:         func(id string, p *platform) field.Node { return newDelay(id, p.clock) },
+	})
+}
+
+func newDelay(id string, c *clock) *delay {
+	d := &delay{
+		clock: c,
+		lines: [2]*modLine{newModLine(int(maxDelay*sRate) + 2), newModLine(int(maxDelay*sRate) + 2)},
+	}
+	d.effect = newEffect(id, newParams(
+		floatP("time", 1, maxDelay*1000, 375, "ms"),
+		floatP("sync", 0, 64, 0, "beats"), // 0 = use time
+		floatP("feedback", 0, maxFeedback, 0.4, ""),
+		floatP("damping", 0, 1, 0.3, ""), // high-frequency loss per repeat
+		floatP("mix", 0, 1, 0.3, ""),
+		boolP("pingpong", false),
+		readOnly(floatP("bpm", 1, 999, 120, "")), // from the clock
+	), d)
+	c.subscribe(d)
+	return d
+}
+
+func (d *delay) stop() {
+	d.clock.unsubscribe(d) // first, so the clock isn't stuck telling us
+	d.effect.stop()
+}
+
+// target is the delay time the params ask for, in samples.
+func (d *delay) target() float64 {
+	seconds := d.params.float("time") / 1000
+	if beats := d.params.float("sync"); beats > 0 {
+		seconds = beats * 60 / d.params.float("bpm")
+	}
+	return math.Max(1, math.Min(seconds, maxDelay)*sRate)
+}
+
+func (d *delay) process(in []float32) []float32 {
+	left, right := d.echo(in)
+	wet := left
+	if d.params.bool("pingpong") {
+		for i := range wet {
+			wet[i] += right[i] // the output's mono, for now
+		}
+	}
+	return mix(in, wet, float32(d.params.float("mix")))
+}
+
+// echo is the wet signal, a side at a time. Plain, the sides are the same; in
+// ping-pong mode, the input goes to the left, and each side's repeats feed
+// the other's.
+func (d *delay) echo(in []float32) (left, right []float32) {
+	var (
+		target   = d.target()
+		feedback = float32(d.params.float("feedback"))
+		damping  = float32(d.params.float("damping"))
+		slew     = 1 - math.Exp(-1/(delaySlew*sRate)) // one-pole, per sample
+		pingpong = d.params.bool("pingpong")
+	)
+	if d.delay <= 0 {
+		d.delay = target // first buffer
+	}
+
+	left, right = make([]float32, len(in)), make([]float32, len(in))
+	for i, x := range in {
+		d.delay += slew * (target - d.delay)
+		l, r := d.lines[0].read(d.delay), d.lines[1].read(d.delay)
+		d.damp[0] += (1 - 0.95*damping) * (l - d.damp[0])
+		d.damp[1] += (1 - 0.95*damping) * (r - d.damp[1])
+
+		if pingpong {
+			d.lines[0].write(x + feedback*d.damp[1])
+			d.lines[1].write(feedback * d.damp[0])
+		} else {
+			d.lines[0].write(x + feedback*d.damp[0])
+			d.lines[1].write(0)
+			r = l
+		}
+		left[i], right[i] = l, r
+	}
+	return left, right
+}
+
+func (d *delay) parse(input string) {
+	toks := strings.Split(strings.TrimSpace(strings.ToLower(input)), " ")
+	switch toks[0] {
+	case "time", "ms":
+		if setParam(d, append([]string{"time"}, toks[1:]...)) {
+			d.params.set("sync", "0")
+		}
+
+	case "sync":
+		if len(toks) < 2 {
+			log.Printf("%s: %s: not enough", d.ID(), input)
+			return
+		}
+		beats, err := parseNoteValue(toks[1])
+		if err != nil {
+			log.Printf("%s: %s: %s", d.ID(), input, err)
+			return
+		}
+		setParam(d, []string{"sync", strconv.FormatFloat(beats, 'f', -1, 64)})
+
+	default:
+		d.effect.parse(input)
+	}
+}
+
+func (d *delay) tick(position)     {}
+func (d *delay) tempo(bpm float32) { d.params.report("bpm", float64(bpm)) }
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestDelayTime(t *testing.T) {
+	c := newClock(120)
+	defer c.stop()
+	d := newDelay("delay", c)
+	defer d.stop()
+
+	for _, cmd := range []struct {
+		input    string
+		bpm      float32
+		expected float64 // seconds
+	}{
+		{"time 250", 120, 0.250},
+		{"sync 1/8", 120, 0.250},
+		{"sync 1/16.", 120, 0.1875},
+		{"sync 1/4", 90, 0.6667},
+		{"time 100", 90, 0.100},
+	} {
+		d.parse(cmd.input)
+		d.tempo(cmd.bpm)
+		if got := d.target() / sRate; math.Abs(got-cmd.expected) > 0.0001 {
+			t.Errorf("%s at %v bpm: expected %.4fs, got %.4fs", cmd.input, cmd.bpm, cmd.expected, got)
+		}
+	}
+}
+
+func TestDelayEcho(t *testing.T) {
+	c := newClock(120)
+	defer c.stop()
+	d := newDelay("delay", c)
+	defer d.stop()
+	d.parse("time 10")
+	d.parse("mix 1")
+	d.parse("feedback 0")
+
+	// An impulse comes back 441 samples later.
+	in := make([]float32, bufSz)
+	in[0] = 1
+	out := d.process(in)
+	for i, v := range out {
+		if expected := boolValue(i == 441); math.Abs(float64(v)-expected) > 0.001 {
+			t.Errorf("sample %d: expected %.3f, got %.3f", i, expected, v)
+		}
+	}
+}
+
+func TestDelayPingPong(t *testing.T) {
+	c := newClock(120)
+	defer c.stop()
+	d := newDelay("delay", c)
+	defer d.stop()
+	d.parse("time 10")
+	d.parse("feedback 0.5")
+	d.parse("damping 0")
+	d.parse("pingpong on")
+
+	// An impulse's repeats go left, right, left, 441 samples apart.
+	in := make([]float32, 4*441)
+	in[0] = 1
+	left, right := d.echo(in)
+	for _, x := range []struct {
+		at          int
+		left, right float64
+	}{
+		{441, 1, 0},
+		{882, 0, 0.5},
+		{1323, 0.25, 0},
+	} {
+		if l, r := float64(left[x.at]), float64(right[x.at]); math.Abs(l-x.left) > 0.01 || math.Abs(r-x.right) > 0.01 {
+			t.Errorf("sample %d: expected %.2f left, %.2f right, got %.2f, %.2f", x.at, x.left, x.right, l, r)
+		}
+	}
+}
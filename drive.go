@@ -0,0 +1,174 @@
+package main
+
+import (
+	"math"
+	"strconv"
+
+	"github.com/peterbourgon/field"
+)
+
+const firTapsPerPhase = 16 // of the oversampling filters, per factor
+
+// drive is a distortion effect. Its tanh, clip and fold shapers run
+// oversampled, so the harmonics they add above Nyquist are filtered away
+// rather than aliased back down. Crush reduces bit depth and sample rate,
+// and aliases on purpose.
+type drive struct {
+	*effect
+	factor  int // oversampling the filters are built for
+	up      *fir
+	down    *fir
+	held    float64 // crush's sample-and-hold
+	holdFor int
+	tone    float64 // state of the tone filter
+}
+
+var driveShapes = map[string]func(float64) float64{
+	"tanh": math.Tanh,
+	"clip": func(x float64) float64 { return math.Max(-1, math.Min(1, x)) },
+	"fold": fold,
+}
+
+func init() {
+	registerNodeType(nodeType{
+		name:        "drive",
+		description: "oversampled distortion effect",
+		new:         func(id string, p *platform) field.Node { return newDrive(id) },
+	})
+}
+
+func newDrive(id string) *drive {
+	d := &drive{}
+	d.effect = newEffect(id, newParams(
+		enumP("mode", "tanh", "tanh", "clip", "fold", "crush"),
+		floatP("drive", 0, 48, 12, "dB"),
+		floatP("bias", -1, 1, 0, ""),  // asymmetry, for even harmonics
+		floatP("tone", 0, 1, 0.7, ""), // low-pass after the shaper; 1 = open
+		floatP("mix", 0, 1, 1, ""),
+		enumP("oversample", "4", "1", "2", "4"),
+		intP("bits", 1, 24, 8), // crush
+		intP("hold", 1, 64, 4), // crush: samples to hold, for a lower rate
+	), d)
+	return d
+}
+
+func (d *drive) process(in []float32) []float32 {
+	var (
+		mode  = d.params.enum("mode")
+		gain  = math.Pow(10, d.params.float("drive")/20)
+		bias  = d.params.float("bias")
+		tone  = 1 - math.Exp(-2*math.Pi*(500*math.Pow(40, d.params.float("tone")))/sRate)
+		wet   = make([]float32, len(in))
+		shape func(float64) float64
+	)
+	if mode == "crush" {
+		crush, clip := d.crusher(d.params.int("bits"), d.params.int("hold")), driveShapes["clip"]
+		shape = func(x float64) float64 { return crush(clip(x*gain + bias)) }
+	} else {
+		f := driveShapes[mode]
+		shape = func(x float64) float64 { return f(x*gain+bias) - f(bias) }
+	}
+
+	factor, _ := strconv.Atoi(d.params.enum("oversample"))
+	if mode == "crush" {
+		factor = 1 // its aliasing is the point
+	}
+	if factor != d.factor {
+		d.factor, d.up, d.down = factor, newOversamplingFIR(factor), newOversamplingFIR(factor)
+	}
+
+	for i, x := range in {
+		var y float64
+		if factor == 1 {
+			y = shape(float64(x))
+		} else {
+			// Stuff zeros between samples, and filter them into an
+			// interpolation; shape that; filter, and keep every factor'th.
+			for j := 0; j < factor; j++ {
+				v := 0.0
+				if j == 0 {
+					v = float64(factor) * float64(x)
+				}
+				s := d.down.process(shape(d.up.process(v)))
+				if j == 0 {
+					y = s
+				}
+			}
+		}
+		d.tone += tone * (y - d.tone)
+		wet[i] = float32(d.tone)
+	}
+	return mix(in, wet, float32(d.params.float("mix")))
+}
+
+// crusher quantizes to the given bit depth, and holds each value for hold
+// samples.
+func (d *drive) crusher(bits, hold int) func(float64) float64 {
+	steps := math.Pow(2, float64(bits-1))
+	return func(x float64) float64 {
+		if d.holdFor--; d.holdFor <= 0 {
+			d.held, d.holdFor = math.Floor(x*steps+0.5)/steps, hold
+		}
+		return d.held
+	}
+}
+
+// fold reflects a signal back and forth between -1 and 1.
+func fold(x float64) float64 {
+	x = math.Mod(x+1, 4)
+	if x < 0 {
+		x += 4
+	}
+	if x > 2 {
+		x = 4 - x
+	}
+	return x - 1
+}
+
+// fir is a finite impulse response filter, one sample at a time.
+type fir struct {
+	taps    []float64
+	history []float64 // ring, newest at i
+	i       int
+}
+
+// newOversamplingFIR is a windowed-sinc low-pass at the original Nyquist,
+// for oversampling by factor. With factor 1, it passes its input through.
+func newOversamplingFIR(factor int) *fir {
+	if factor <= 1 {
+		return &fir{taps: []float64{1}, history: make([]float64, 1)}
+	}
+	n := firTapsPerPhase*factor + 1
+	taps := make([]float64, n)
+	cutoff := 0.9 / float64(factor) // of the oversampled Nyquist, with a little guard
+	var sum float64
+	for k := range taps {
+		m := float64(k) - float64(n-1)/2
+		sinc := 1.0
+		if m != 0 {
+			sinc = math.Sin(math.Pi*cutoff*m) / (math.Pi * cutoff * m)
+		}
+		w := 2 * math.Pi * float64(k) / float64(n-1)
+		window := 0.42 - 0.5*math.Cos(w) + 0.08*math.Cos(2*w) // Blackman
+		taps[k] = cutoff * sinc * window
+		sum += taps[k]
+	}
+	for k := range taps {
+		taps[k] /= sum // unity gain at DC
+	}
+	return &fir{taps: taps, history: make([]float64, n)}
+}
+
+func (f *fir) process(x float64) float64 {
+	f.i = (f.i + 1) % len(f.history)
+	f.history[f.i] = x
+	var y float64
+	j := f.i
+	for _, t := range f.taps {
+		y += t * f.history[j]
+		if j--; j < 0 {
+			j = len(f.history) - 1
+		}
+	}
+	return y
+}
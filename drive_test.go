@@ -0,0 +1,65 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestFold(t *testing.T) {
+	for _, c := range []struct {
+		x, expected float64
+	}{
+		{0, 0},
+		{0.5, 0.5},
+		{1, 1},
+		{1.5, 0.5},
+		{3, -1},
+		{-1.25, -0.75},
+		{4.5, 0.5},
+	} {
+		if got := fold(c.x); math.Abs(got-c.expected) > 1e-9 {
+			t.Errorf("fold(%v): expected %v, got %v", c.x, c.expected, got)
+		}
+	}
+}
+
+func TestDriveAliasing(t *testing.T) {
+	// Hard clipping a 15 kHz sine makes harmonics at 45 kHz, 75 kHz..., which
+	// alias to 0.9 kHz, 13.5 kHz... at sRate. Oversampled, much less of them
+	// should come through at 0.9 kHz.
+	alias := func(oversample string) float64 {
+		d := newDrive("drive")
+		defer d.stop()
+		d.parse("mode clip")
+		d.parse("drive 24")
+		d.parse("tone 1")
+		d.parse("oversample " + oversample)
+
+		var phase float64
+		var out []float32
+		for n := 0; n < 8; n++ {
+			in := make([]float32, bufSz)
+			for i := range in {
+				in[i] = float32(0.5 * math.Sin(2*math.Pi*phase))
+				phase += 15000.0 / sRate
+			}
+			out = append(out, d.process(in)...)
+		}
+		return magnitude(out[bufSz:], 900)
+	}
+
+	plain, oversampled := alias("1"), alias("4")
+	if oversampled > plain/4 {
+		t.Errorf("expected 4x oversampling to cut aliasing at 900 Hz, got %.4f vs %.4f", oversampled, plain)
+	}
+}
+
+// magnitude is the amplitude of one frequency in buf, by correlation.
+func magnitude(buf []float32, hz float64) float64 {
+	var re, im float64
+	for i, v := range buf {
+		re += float64(v) * math.Cos(2*math.Pi*hz*float64(i)/sRate)
+		im += float64(v) * math.Sin(2*math.Pi*hz*float64(i)/sRate)
+	}
+	return 2 * math.Hypot(re, im) / float64(len(buf))
+}
@@ -0,0 +1,338 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/peterbourgon/field"
+)
+
+// processor is the signal processing of an effect. It's called from the
+// effect's loop with each buffer of input, and returns a buffer of output.
+// It should read its parameters from the effect's params as it goes.
+type processor interface {
+	process(in []float32) []float32
+}
+
+// sidechainProcessor is a processor that also takes a sidechain: audio from
+// the source chosen with its effect's sidechain command, which isn't mixed
+// into the input. side is nil when no source is chosen.
+type sidechainProcessor interface {
+	processSidechain(in, side []float32) []float32
+}
+
+// effectInput is a connection from upstream, where it's from, and the buffer
+// it's sent that hasn't been rendered yet.
+type effectInput struct {
+	src string
+	c   <-chan []float32
+	buf []float32
+}
+
+// arrival is a buffer from an input, or its closing if !ok.
+type arrival struct {
+	c   <-chan []float32
+	buf []float32
+	ok  bool
+}
+
+// effect is a node that receives audio like the mixer does, runs it through
+// a processor, and sends it downstream like a generator does. Effect nodes
+// embed one, and give it themselves as its processor.
+//
+// It renders when every input has sent a buffer, or a buffer's worth of time
+// after the first one did, with silence for the rest. It takes no more until
+// its outputs have the last one, so a chain of effects runs at the pace of
+// whatever's at the end of it. With no outputs, it reads and throws away its
+// input at the pace it would play, so whatever feeds it isn't held up; with
+// no inputs, it renders silence at that pace, for tails to ring out.
+type effect struct {
+	id          string
+	params      *params
+	proc        processor
+	incoming    chan effectInput // connections from upstream
+	arrivals    chan arrival     // their buffers
+	sidechains  chan string      // sources to treat as the sidechain
+	connects    chan connectRequest
+	disconnects chan string
+	levels      chan levelRequest
+	outputs     outputs
+	quit        chan chan struct{}
+	done        chan struct{} // closed on quit, for the inputs' goroutines
+}
+
+func newEffect(id string, ps *params, proc processor) *effect {
+	e := &effect{
+		id:          id,
+		params:      ps,
+		proc:        proc,
+		incoming:    make(chan effectInput),
+		arrivals:    make(chan arrival),
+		sidechains:  make(chan string),
+		connects:    make(chan connectRequest),
+		disconnects: make(chan string),
+		levels:      make(chan levelRequest),
+		outputs:     outputs{},
+		quit:        make(chan chan struct{}),
+		done:        make(chan struct{}),
+	}
+	go e.loop()
+	return e
+}
+
+func (e *effect) stop() {
+	q := make(chan struct{})
+	e.quit <- q
+	<-q
+}
+
+func (e *effect) loop() {
+	log.Printf("%s: started", e.ID())
+	defer log.Printf("%s: done", e.ID())
+
+	var (
+		inputs  []*effectInput
+		side    string
+		late    <-chan time.Time // for inputs that haven't sent yet
+		resting <-chan time.Time // after throwing a buffer away
+	)
+	render := func() {
+		late = nil
+		if !e.render(inputs, side) {
+			resting = time.After(outputDeadline)
+		}
+	}
+	for {
+		if late == nil && len(inputs) <= 0 && e.outputs.idle() {
+			late = time.After(outputDeadline)
+		}
+		arrivals := e.arrivals
+		if resting != nil || len(e.outputs.list) > 0 && !e.outputs.idle() {
+			arrivals = nil // downstream isn't ready for more
+		}
+		c, buf := e.outputs.next()
+
+		select {
+		case c <- buf:
+			e.outputs.sent()
+
+		case <-e.outputs.expired():
+			e.outputs.skip()
+
+		case a := <-arrivals:
+			in := findInput(inputs, a.c)
+			if !a.ok {
+				log.Printf("%s: input from %s closed", e.ID(), in.src)
+				inputs = removeInput(inputs, in)
+			} else {
+				if in.buf != nil {
+					render() // it's a buffer ahead of the others
+				}
+				in.buf = a.buf
+			}
+			switch {
+			case len(inputs) > 0 && waiting(inputs) <= 0:
+				render()
+			case late == nil:
+				late = time.After(outputDeadline)
+			}
+
+		case <-late:
+			render()
+
+		case <-resting:
+			resting = nil
+
+		case in := <-e.incoming:
+			if len(inputs) <= 0 {
+				late = nil // it was for silence
+			}
+			inputs = append(inputs, &in)
+			go e.forward(in.c)
+
+		case side = <-e.sidechains:
+
+		case r := <-e.connects:
+			if err := e.outputs.connect(e.ID(), r.r); err != nil {
+				r.e <- fmt.Errorf("%s %s", e.ID(), err)
+				continue
+			}
+			r.e <- nil
+			log.Printf("%s → %s", e.ID(), r.r.ID())
+
+		case id := <-e.disconnects:
+			if err := e.outputs.disconnect(id); err != nil {
+				log.Printf("%s: disconnect: %s (bug in field)", e.ID(), err)
+				continue
+			}
+			log.Printf("%s ✕ %s", e.ID(), id)
+
+		case r := <-e.levels:
+			r.e <- e.outputs.setLevel(r.id, r.level)
+
+		case q := <-e.quit:
+			close(e.done)
+			e.outputs.closeAll()
+			close(q)
+			return
+		}
+	}
+}
+
+// forward passes an input's buffers to the loop, until it closes or the
+// effect stops.
+func (e *effect) forward(c <-chan []float32) {
+	for {
+		var a arrival
+		select {
+		case a.buf, a.ok = <-c:
+			a.c = c
+		case <-e.done:
+			return
+		}
+		select {
+		case e.arrivals <- a:
+		case <-e.done:
+			return
+		}
+		if !a.ok {
+			return
+		}
+	}
+}
+
+// render sums the buffers the inputs have sent, and the ones from the
+// sidechain source, if there is one, separately, with silence for inputs
+// that haven't sent, and loads the processed result for the outputs. With no
+// outputs, it throws the input away, and returns false.
+func (e *effect) render(inputs []*effectInput, side string) bool {
+	in, sc := make([]float32, bufSz), []float32(nil)
+	if side != "" {
+		sc = make([]float32, bufSz)
+	}
+	for _, input := range inputs {
+		buf, sum := input.buf, in
+		input.buf = nil
+		if side != "" && input.src == side {
+			sum = sc
+		}
+		if len(buf) != bufSz {
+			continue // not sent, or not audio we can use
+		}
+		for i := range sum {
+			sum[i] += buf[i]
+		}
+	}
+
+	if len(e.outputs.list) <= 0 {
+		return false
+	}
+	if sp, ok := e.proc.(sidechainProcessor); ok {
+		e.outputs.load(sp.processSidechain(in, sc))
+	} else {
+		e.outputs.load(e.proc.process(in))
+	}
+	return true
+}
+
+// waiting is how many inputs haven't sent a buffer since the last render.
+func waiting(inputs []*effectInput) int {
+	n := 0
+	for _, in := range inputs {
+		if in.buf == nil {
+			n++
+		}
+	}
+	return n
+}
+
+func findInput(inputs []*effectInput, c <-chan []float32) *effectInput {
+	for _, in := range inputs {
+		if in.c == c {
+			return in
+		}
+	}
+	return nil
+}
+
+func removeInput(inputs []*effectInput, in *effectInput) []*effectInput {
+	survivors := make([]*effectInput, 0, len(inputs))
+	for _, i := range inputs {
+		if i != in {
+			survivors = append(survivors, i)
+		}
+	}
+	return survivors
+}
+
+// parse handles the commands every effect takes: levels, and parameters.
+// Effects with more to say handle that first, and pass the rest on.
+func (e *effect) parse(input string) {
+	input = strings.TrimSpace(strings.ToLower(input))
+	toks := strings.Split(input, " ")
+	if len(toks) <= 0 {
+		log.Printf("%s: parse empty", e.ID())
+		return
+	}
+
+	switch toks[0] {
+	case "level", "lvl":
+		requestLevel(e.ID(), e.levels, toks)
+
+	default:
+		if !e.params.has(toks[0]) {
+			log.Printf("%s: %s: aroo", e.ID(), input)
+			return
+		}
+		setParam(e, toks)
+	}
+}
+
+func (e *effect) ID() string          { return e.id }
+func (e *effect) parameters() *params { return e.params }
+
+// modulate implements the modulatable interface.
+func (e *effect) modulate(param string, value float64) error {
+	return e.params.modulate(param, value)
+}
+
+// receive implements the audioReceiver interface.
+func (e *effect) receive(audioOut <-chan []float32) {
+	e.incoming <- effectInput{src: "", c: audioOut}
+}
+
+// receiveFrom implements the sourceReceiver interface.
+func (e *effect) receiveFrom(src string, audioOut <-chan []float32) {
+	e.incoming <- effectInput{src: src, c: audioOut}
+}
+
+func (e *effect) Connect(n field.Node) error {
+	r, ok := n.(audioReceiver)
+	if !ok {
+		return fmt.Errorf("%s not audioReceiver", n.ID())
+	}
+	req := connectRequest{r, make(chan error)}
+	e.connects <- req
+	return <-req.e
+}
+
+func (e *effect) Disconnect(n field.Node) {
+	if _, ok := n.(audioReceiver); !ok {
+		log.Printf("%s not audioReceiver", n.ID())
+		return
+	}
+	e.disconnects <- n.ID()
+}
+
+func (e *effect) Connection(n field.Node) error { return nil } // upstream calls our receive()
+func (e *effect) Disconnection(n field.Node)    {}
+
+// mix blends wet into dry, in place, by amount [0..1], and returns dry.
+func mix(dry, wet []float32, amount float32) []float32 {
+	for i := range dry {
+		dry[i] += amount * (wet[i] - dry[i])
+	}
+	return dry
+}
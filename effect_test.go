@@ -0,0 +1,80 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// doubler is a processor that doubles its input.
+type doubler struct{}
+
+func (doubler) process(in []float32) []float32 {
+	for i := range in {
+		in[i] *= 2
+	}
+	return in
+}
+
+func constant(v float32) []float32 {
+	buf := make([]float32, bufSz)
+	for i := range buf {
+		buf[i] = v
+	}
+	return buf
+}
+
+func TestEffectWithoutOutputs(t *testing.T) {
+	// Nothing downstream, but whatever feeds it still gets to send.
+	e := newEffect("fx", newParams(), doubler{})
+	defer e.stop()
+	in := make(chan []float32, 1)
+	e.receive(in)
+	for i := 0; i < 5; i++ {
+		select {
+		case in <- constant(1):
+		case <-time.After(time.Second):
+			t.Fatalf("buffer %d: expected the effect to read it", i)
+		}
+	}
+}
+
+func TestEffectRendersOnInput(t *testing.T) {
+	e := newEffect("fx", newParams(), doubler{})
+	defer e.stop()
+	out := &chanReceiver{id: "out"}
+	req := connectRequest{out, make(chan error)}
+	e.connects <- req
+	if err := <-req.e; err != nil {
+		t.Fatal(err)
+	}
+	a, b := make(chan []float32, 1), make(chan []float32, 1)
+	e.receive(a)
+	e.receive(b)
+
+	// Late inputs aren't rendered as silence ahead of time: each buffer out
+	// is every input's buffer in, however long they take.
+	for i := 1; i <= 3; i++ {
+		a <- constant(float32(i))
+		time.Sleep(outputDeadline / 4)
+		b <- constant(float32(i))
+		select {
+		case buf := <-out.c:
+			if expected := float32(4 * i); buf[0] != expected {
+				t.Errorf("buffer %d: expected %v, got %v", i, expected, buf[0])
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("buffer %d: expected output", i)
+		}
+	}
+
+	// One that doesn't send at all is silent, after a buffer's time.
+	a <- constant(1)
+	select {
+	case buf := <-out.c:
+		if buf[0] != 2 {
+			t.Errorf("without b: expected 2, got %v", buf[0])
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("without b: expected output")
+	}
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"math/cmplx"
+	"strings"
+
+	"github.com/peterbourgon/field"
+)
+
+// responder is a node that can report its magnitude response, in dB at each
+// of the given frequencies, for a client to draw.
+type responder interface {
+	response(hz []float64) []float64
+}
+
+// eqBand is one band of an eq: a biquad of some kind, set by the params
+// named after the band.
+type eqBand struct {
+	name string
+	kind string // of biquad: highpass, lowshelf, peak, highshelf or lowpass
+	hz   float64
+	cut  bool // a cut, with no gain, that's off until it's switched on
+	f    biquad
+}
+
+// eq is a parametric equalizer: a high-pass (lowcut), low shelf, three peaking
+// bells, high shelf and low-pass (highcut), in series. Each band has params
+// for its frequency, gain and Q, named like bell1.freq, bell1.gain, bell1.q.
+// Cuts have no gain, and are switched with lowcut.on and highcut.on.
+type eq struct {
+	*effect
+	bands []*eqBand
+}
+
+func init() {
+	registerNodeType(nodeType{
+		name:        "eq",
+		description: "parametric equalizer effect",
+		new:         func(id string, p *platform) field.Node { return newEQ(id) },
+	})
+}
+
+func newEQ(id string) *eq {
+	e := &eq{bands: []*eqBand{
+		{name: "lowcut", kind: "highpass", hz: 30, cut: true},
+		{name: "lowshelf", kind: "lowshelf", hz: 100},
+		{name: "bell1", kind: "peak", hz: 250},
+		{name: "bell2", kind: "peak", hz: 1000},
+		{name: "bell3", kind: "peak", hz: 4000},
+		{name: "highshelf", kind: "highshelf", hz: 8000},
+		{name: "highcut", kind: "lowpass", hz: 18000, cut: true},
+	}}
+	ps := []*param{}
+	for _, b := range e.bands {
+		ps = append(ps, floatP(b.name+".freq", 20, 20000, b.hz, "Hz"))
+		if b.cut {
+			ps = append(ps, boolP(b.name+".on", false))
+		} else {
+			ps = append(ps, floatP(b.name+".gain", -24, 24, 0, "dB"))
+		}
+		ps = append(ps, floatP(b.name+".q", 0.1, 18, 0.707, ""))
+	}
+	e.effect = newEffect(id, newParams(ps...), e)
+	return e
+}
+
+func (e *eq) process(in []float32) []float32 {
+	out := make([]float32, len(in))
+	copy(out, in)
+	for _, b := range e.bands {
+		on, coeffs := e.design(b)
+		if !on {
+			continue
+		}
+		b.f.set(coeffs)
+		for i, x := range out {
+			out[i] = float32(b.f.process(float64(x)))
+		}
+	}
+	return out
+}
+
+// parse takes "<band> <freq> <gain> <q>" to set a band at once, leaving off
+// any from the end, as well as the usual commands. Cuts take no gain, and
+// setting them switches them on.
+func (e *eq) parse(input string) {
+	toks := strings.Split(strings.TrimSpace(strings.ToLower(input)), " ")
+	for _, b := range e.bands {
+		if toks[0] != b.name {
+			continue
+		}
+		names := []string{"freq", "gain", "q"}
+		if b.cut {
+			names = []string{"freq", "q"}
+		}
+		if len(toks) < 2 || len(toks) > len(names)+1 {
+			log.Printf("%s: %s: want %s <%s>", e.ID(), input, b.name, strings.Join(names, "> <"))
+			return
+		}
+		for i, v := range toks[1:] {
+			if !setParam(e, []string{b.name + "." + names[i], v}) {
+				return
+			}
+		}
+		if b.cut {
+			setParam(e, []string{b.name + ".on", "on"})
+		}
+		return
+	}
+	e.effect.parse(input)
+}
+
+// design returns whether a band is on, and its coefficients as the params
+// have them now.
+func (e *eq) design(b *eqBand) (bool, biquad) {
+	var gain float64
+	if b.cut {
+		if !e.params.bool(b.name + ".on") {
+			return false, biquad{}
+		}
+	} else {
+		gain = e.params.float(b.name + ".gain")
+	}
+	return true, rbj(b.kind, e.params.float(b.name+".freq"), gain, e.params.float(b.name+".q"))
+}
+
+// response implements the responder interface. It reads only the params, so
+// it's safe to call from outside the loop.
+func (e *eq) response(hz []float64) []float64 {
+	db := make([]float64, len(hz))
+	for _, b := range e.bands {
+		on, f := e.design(b)
+		if !on {
+			continue
+		}
+		for i, h := range hz {
+			db[i] += 20 * math.Log10(f.magnitude(h))
+		}
+	}
+	return db
+}
+
+// biquad is a second-order IIR filter, direct form I.
+type biquad struct {
+	b0, b1, b2, a1, a2 float64 // normalized so a0 = 1
+	x1, x2, y1, y2     float64
+}
+
+// set changes the coefficients, keeping the state.
+func (f *biquad) set(c biquad) {
+	f.b0, f.b1, f.b2, f.a1, f.a2 = c.b0, c.b1, c.b2, c.a1, c.a2
+}
+
+func (f *biquad) process(x float64) float64 {
+	y := f.b0*x + f.b1*f.x1 + f.b2*f.x2 - f.a1*f.y1 - f.a2*f.y2
+	f.x2, f.x1 = f.x1, x
+	f.y2, f.y1 = f.y1, y
+	return y
+}
+
+// magnitude is the filter's gain at hz.
+func (f *biquad) magnitude(hz float64) float64 {
+	z := cmplx.Exp(complex(0, -2*math.Pi*hz/sRate)) // z^-1
+	num := complex(f.b0, 0) + complex(f.b1, 0)*z + complex(f.b2, 0)*z*z
+	den := 1 + complex(f.a1, 0)*z + complex(f.a2, 0)*z*z
+	return cmplx.Abs(num / den)
+}
+
+// rbj designs a biquad from Robert Bristow-Johnson's Audio EQ Cookbook.
+func rbj(kind string, hz, gainDB, q float64) biquad {
+	var (
+		w     = 2 * math.Pi * math.Min(hz, 0.49*sRate) / sRate
+		cosw  = math.Cos(w)
+		alpha = math.Sin(w) / (2 * q)
+		a     = math.Pow(10, gainDB/40)
+		sqA   = 2 * math.Sqrt(a) * alpha
+
+		b0, b1, b2, a0, a1, a2 float64
+	)
+	switch kind {
+	case "lowpass":
+		b0, b1, b2 = (1-cosw)/2, 1-cosw, (1-cosw)/2
+		a0, a1, a2 = 1+alpha, -2*cosw, 1-alpha
+	case "highpass":
+		b0, b1, b2 = (1+cosw)/2, -(1 + cosw), (1+cosw)/2
+		a0, a1, a2 = 1+alpha, -2*cosw, 1-alpha
+	case "peak":
+		b0, b1, b2 = 1+alpha*a, -2*cosw, 1-alpha*a
+		a0, a1, a2 = 1+alpha/a, -2*cosw, 1-alpha/a
+	case "lowshelf":
+		b0 = a * ((a + 1) - (a-1)*cosw + sqA)
+		b1 = 2 * a * ((a - 1) - (a+1)*cosw)
+		b2 = a * ((a + 1) - (a-1)*cosw - sqA)
+		a0 = (a + 1) + (a-1)*cosw + sqA
+		a1 = -2 * ((a - 1) + (a+1)*cosw)
+		a2 = (a + 1) + (a-1)*cosw - sqA
+	case "highshelf":
+		b0 = a * ((a + 1) + (a-1)*cosw + sqA)
+		b1 = -2 * a * ((a - 1) + (a+1)*cosw)
+		b2 = a * ((a + 1) + (a-1)*cosw - sqA)
+		a0 = (a + 1) - (a-1)*cosw + sqA
+		a1 = 2 * ((a - 1) - (a+1)*cosw)
+		a2 = (a + 1) - (a-1)*cosw - sqA
+	default:
+		panic(fmt.Sprintf("rbj: no %s", kind))
+	}
+	return biquad{b0: b0 / a0, b1: b1 / a0, b2: b2 / a0, a1: a1 / a0, a2: a2 / a0}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestEQResponse(t *testing.T) {
+	for _, c := range []struct {
+		cmds     []string
+		hz       float64
+		expected float64 // dB
+	}{
+		{nil, 1000, 0}, // flat
+		{[]string{"bell2 1000 6 1"}, 1000, 6},
+		{[]string{"bell2 1000 -12 4"}, 1000, -12},
+		{[]string{"bell2 1000 -12 4"}, 4000, -0.1},
+		{[]string{"lowshelf 100 9"}, 20, 8.9},
+		{[]string{"highshelf 8000 -6"}, 18000, -6},
+		{[]string{"lowcut 200"}, 200, -3.01},
+		{[]string{"lowcut 200"}, 50, -24.1},
+		{[]string{"highcut 5000"}, 5000, -3.01},
+		{[]string{"highcut 5000", "highcut.on off"}, 5000, 0},
+		{[]string{"bell1 250 3", "bell3 250 3"}, 250, 6}, // bands add
+	} {
+		e := newEQ("eq")
+		for _, cmd := range c.cmds {
+			e.parse(cmd)
+		}
+		got := e.response([]float64{c.hz})[0]
+		if math.Abs(got-c.expected) > 0.1 {
+			t.Errorf("%v at %v Hz: expected %.2f dB, got %.2f dB", c.cmds, c.hz, c.expected, got)
+		}
+
+		// What it does should match what it says.
+		if measured := 20 * math.Log10(gain(e, c.hz)); math.Abs(measured-got) > 0.2 {
+			t.Errorf("%v at %v Hz: response says %.2f dB, measured %.2f dB", c.cmds, c.hz, got, measured)
+		}
+		e.stop()
+	}
+}
@@ -0,0 +1,370 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Limits on expressions, which arrive over the network. An expression can
+// only do arithmetic, so these bound how long it takes to compile and run.
+const (
+	maxExprLen   = 512                   // characters
+	maxExprNodes = 256                   // operations, after parsing
+	maxExprDepth = 32                    // nesting
+	exprTimeout  = 50 * time.Millisecond // to fill a wavetable
+	wavetableSz  = 4096
+)
+
+// exprFunc is a compiled expression of x.
+type exprFunc func(x float64) float64
+
+var exprConsts = map[string]float64{
+	"pi":  math.Pi,
+	"tau": 2 * math.Pi,
+	"e":   math.E,
+}
+
+var exprFuncs1 = map[string]func(float64) float64{
+	"sin":   math.Sin,
+	"cos":   math.Cos,
+	"tan":   math.Tan,
+	"asin":  math.Asin,
+	"acos":  math.Acos,
+	"atan":  math.Atan,
+	"sinh":  math.Sinh,
+	"cosh":  math.Cosh,
+	"tanh":  math.Tanh,
+	"abs":   math.Abs,
+	"sqrt":  math.Sqrt,
+	"exp":   math.Exp,
+	"log":   math.Log,
+	"floor": math.Floor,
+	"ceil":  math.Ceil,
+	"round": math.Round,
+	"sign": func(v float64) float64 {
+		switch {
+		case v > 0:
+			return 1
+		case v < 0:
+			return -1
+		}
+		return 0
+	},
+}
+
+var exprFuncs2 = map[string]func(float64, float64) float64{
+	"min":   math.Min,
+	"max":   math.Max,
+	"pow":   math.Pow,
+	"mod":   math.Mod,
+	"atan2": math.Atan2,
+}
+
+// compileExpr compiles an arithmetic expression of x: numbers, the constants
+// pi, tau and e, the operators + - * / % ^, parentheses, and a fixed set of
+// math functions. Nothing else is reachable from an expression.
+func compileExpr(src string) (exprFunc, error) {
+	if len(src) > maxExprLen {
+		return nil, fmt.Errorf("expression too long (max %d)", maxExprLen)
+	}
+	toks, err := lexExpr(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &exprParser{toks: toks}
+	f, err := p.expr(0)
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.toks) {
+		return nil, fmt.Errorf("unexpected %q", p.toks[p.pos])
+	}
+	return f, nil
+}
+
+// exprWaveform compiles an expression of phase x [0..1] into a full-cycle
+// waveform. It's evaluated once, into a wavetable, within exprTimeout; if it
+// peaks past 1, it's scaled down.
+func exprWaveform(src string) (waveform, error) {
+	f, err := compileExpr(src)
+	if err != nil {
+		return waveform{}, err
+	}
+
+	table := make([]float32, wavetableSz+1) // +1 to wrap, for interpolation
+	deadline := time.Now().Add(exprTimeout)
+	var peak float64
+	for i := 0; i < wavetableSz; i++ {
+		if i%64 == 0 && time.Now().After(deadline) {
+			return waveform{}, fmt.Errorf("took too long")
+		}
+		v := f(float64(i) / wavetableSz)
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return waveform{}, fmt.Errorf("not a number at x=%v", float64(i)/wavetableSz)
+		}
+		peak = math.Max(peak, math.Abs(v))
+		table[i] = float32(v)
+	}
+	if peak > 1 {
+		for i := range table {
+			table[i] /= float32(peak)
+		}
+	}
+	table[wavetableSz] = table[0]
+
+	return waveform{cycle: func(phase, _ float32) float32 {
+		pos := phase * wavetableSz
+		i := int(pos)
+		if i < 0 || i >= wavetableSz {
+			return table[0]
+		}
+		frac := pos - float32(i)
+		return (1-frac)*table[i] + frac*table[i+1]
+	}}, nil
+}
+
+func lexExpr(src string) ([]string, error) {
+	toks := []string{}
+	rs := []rune(src)
+	for i := 0; i < len(rs); {
+		r := rs[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case unicode.IsDigit(r) || r == '.':
+			j := i
+			for j < len(rs) && (unicode.IsDigit(rs[j]) || rs[j] == '.') {
+				j++
+			}
+			toks = append(toks, string(rs[i:j]))
+			i = j
+		case unicode.IsLetter(r):
+			j := i
+			for j < len(rs) && (unicode.IsLetter(rs[j]) || unicode.IsDigit(rs[j])) {
+				j++
+			}
+			toks = append(toks, strings.ToLower(string(rs[i:j])))
+			i = j
+		case strings.ContainsRune("+-*/%^(),", r):
+			toks = append(toks, string(r))
+			i++
+		default:
+			return nil, fmt.Errorf("unexpected %q", r)
+		}
+	}
+	return toks, nil
+}
+
+// exprParser is a recursive descent parser. Precedence, from low to high:
+// + and -, then * / and %, then unary - and +, then ^ (right associative).
+type exprParser struct {
+	toks  []string
+	pos   int
+	nodes int
+}
+
+func (p *exprParser) peek() string {
+	if p.pos < len(p.toks) {
+		return p.toks[p.pos]
+	}
+	return ""
+}
+
+func (p *exprParser) next() string {
+	t := p.peek()
+	p.pos++
+	return t
+}
+
+func (p *exprParser) expect(t string) error {
+	if got := p.next(); got != t {
+		if got == "" {
+			return fmt.Errorf("expected %q at end", t)
+		}
+		return fmt.Errorf("expected %q, got %q", t, got)
+	}
+	return nil
+}
+
+// node counts an operation, to bound the size of what's compiled.
+func (p *exprParser) node(depth int) error {
+	p.nodes++
+	if p.nodes > maxExprNodes {
+		return fmt.Errorf("expression too big (max %d operations)", maxExprNodes)
+	}
+	if depth > maxExprDepth {
+		return fmt.Errorf("expression nested too deep (max %d)", maxExprDepth)
+	}
+	return nil
+}
+
+func (p *exprParser) expr(depth int) (exprFunc, error) {
+	l, err := p.term(depth)
+	if err != nil {
+		return nil, err
+	}
+	for p.peek() == "+" || p.peek() == "-" {
+		op := p.next()
+		r, err := p.term(depth)
+		if err != nil {
+			return nil, err
+		}
+		if err := p.node(depth); err != nil {
+			return nil, err
+		}
+		l = binaryExpr(op, l, r)
+	}
+	return l, nil
+}
+
+func (p *exprParser) term(depth int) (exprFunc, error) {
+	l, err := p.unary(depth)
+	if err != nil {
+		return nil, err
+	}
+	for p.peek() == "*" || p.peek() == "/" || p.peek() == "%" {
+		op := p.next()
+		r, err := p.unary(depth)
+		if err != nil {
+			return nil, err
+		}
+		if err := p.node(depth); err != nil {
+			return nil, err
+		}
+		l = binaryExpr(op, l, r)
+	}
+	return l, nil
+}
+
+func (p *exprParser) unary(depth int) (exprFunc, error) {
+	switch p.peek() {
+	case "-":
+		p.next()
+		if err := p.node(depth + 1); err != nil {
+			return nil, err
+		}
+		f, err := p.unary(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		return func(x float64) float64 { return -f(x) }, nil
+	case "+":
+		p.next()
+		return p.unary(depth + 1)
+	}
+	return p.power(depth)
+}
+
+func (p *exprParser) power(depth int) (exprFunc, error) {
+	base, err := p.primary(depth)
+	if err != nil {
+		return nil, err
+	}
+	if p.peek() != "^" {
+		return base, nil
+	}
+	p.next()
+	exp, err := p.unary(depth + 1)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.node(depth); err != nil {
+		return nil, err
+	}
+	return binaryExpr("^", base, exp), nil
+}
+
+func (p *exprParser) primary(depth int) (exprFunc, error) {
+	if err := p.node(depth); err != nil {
+		return nil, err
+	}
+	t := p.next()
+	switch {
+	case t == "":
+		return nil, fmt.Errorf("unexpected end")
+
+	case t == "(":
+		f, err := p.expr(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		return f, p.expect(")")
+
+	case t == "x":
+		return func(x float64) float64 { return x }, nil
+
+	case unicode.IsDigit(rune(t[0])) || t[0] == '.':
+		v, err := strconv.ParseFloat(t, 64)
+		if err != nil {
+			return nil, fmt.Errorf("bad number %q", t)
+		}
+		return func(float64) float64 { return v }, nil
+	}
+
+	if v, ok := exprConsts[t]; ok {
+		return func(float64) float64 { return v }, nil
+	}
+	if fn, ok := exprFuncs1[t]; ok {
+		args, err := p.args(depth, 1)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", t, err)
+		}
+		a := args[0]
+		return func(x float64) float64 { return fn(a(x)) }, nil
+	}
+	if fn, ok := exprFuncs2[t]; ok {
+		args, err := p.args(depth, 2)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", t, err)
+		}
+		a, b := args[0], args[1]
+		return func(x float64) float64 { return fn(a(x), b(x)) }, nil
+	}
+	return nil, fmt.Errorf("unknown %q", t)
+}
+
+// args parses a parenthesized list of n arguments.
+func (p *exprParser) args(depth, n int) ([]exprFunc, error) {
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	args := []exprFunc{}
+	for i := 0; i < n; i++ {
+		if i > 0 {
+			if err := p.expect(","); err != nil {
+				return nil, fmt.Errorf("want %d arguments", n)
+			}
+		}
+		f, err := p.expr(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, f)
+	}
+	if err := p.expect(")"); err != nil {
+		return nil, fmt.Errorf("want %d arguments", n)
+	}
+	return args, nil
+}
+
+func binaryExpr(op string, l, r exprFunc) exprFunc {
+	switch op {
+	case "+":
+		return func(x float64) float64 { return l(x) + r(x) }
+	case "-":
+		return func(x float64) float64 { return l(x) - r(x) }
+	case "*":
+		return func(x float64) float64 { return l(x) * r(x) }
+	case "/":
+		return func(x float64) float64 { return l(x) / r(x) }
+	case "%":
+		return func(x float64) float64 { return math.Mod(l(x), r(x)) }
+	case "^":
+		return func(x float64) float64 { return math.Pow(l(x), r(x)) }
+	}
+	panic("unreachable")
+}
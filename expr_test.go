@@ -0,0 +1,81 @@
+package main
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestCompileExpr(t *testing.T) {
+	for _, c := range []struct {
+		src      string
+		x        float64
+		expected float64
+	}{
+		{"1 + 2 * 3", 0, 7},
+		{"(1 + 2) * 3", 0, 9},
+		{"2 ^ 3 ^ 2", 0, 512},
+		{"-2 ^ 2", 0, -4},
+		{"7 % 4", 0, 3},
+		{"x * 2", 0.25, 0.5},
+		{"sin(2*pi*x)", 0.25, 1},
+		{"sin(2*pi*x) + 0.3*sin(6*pi*x)", 0.25, 0.7},
+		{"max(x, 0.5)", 0.25, 0.5},
+		{"pow(2, 10)", 0, 1024},
+		{"abs(-x) - sign(-1)", 0.5, 1.5},
+		{"tau / 2", 0, math.Pi},
+		{"SIN(X)", 0, 0},
+	} {
+		f, err := compileExpr(c.src)
+		if err != nil {
+			t.Errorf("%s: %s", c.src, err)
+			continue
+		}
+		if got := f(c.x); math.Abs(got-c.expected) > 1e-9 {
+			t.Errorf("%s at %v: expected %v, got %v", c.src, c.x, c.expected, got)
+		}
+	}
+}
+
+func TestCompileExprErrors(t *testing.T) {
+	for _, src := range []string{
+		"",
+		"1 +",
+		"(1 + 2",
+		"1 2",
+		"y",
+		"sin(1, 2)",
+		"max(1)",
+		"exec(x)",
+		"x; 1",
+		strings.Repeat("(", 40) + "x" + strings.Repeat(")", 40),
+		strings.Repeat("x+", 300) + "x",
+	} {
+		if _, err := compileExpr(src); err == nil {
+			t.Errorf("%q: expected error", src)
+		}
+	}
+}
+
+func TestExprWaveform(t *testing.T) {
+	w, err := exprWaveform("2 * sin(tau * x)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		phase    float32
+		expected float32
+	}{
+		{0, 0},
+		{0.25, 1}, // scaled down from 2
+		{0.75, -1},
+	} {
+		if got := w.at(c.phase, 0.5); !cmpFloat32(got, c.expected, 0.001) {
+			t.Errorf("at %v: expected %v, got %v", c.phase, c.expected, got)
+		}
+	}
+
+	if _, err := exprWaveform("1 / x"); err == nil {
+		t.Errorf("1 / x: expected error at x=0")
+	}
+}
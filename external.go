@@ -0,0 +1,77 @@
+package main
+
+import (
+	"log"
+	"sync"
+
+	"github.com/peterbourgon/field"
+	"github.com/peterbourgon/gmd/node"
+)
+
+// Node types from other packages register with package node, from their
+// init functions, which run before ours. They're added here like our own.
+func init() {
+	for _, t := range node.Types() {
+		registerNodeType(externalType(t))
+	}
+}
+
+func externalType(t node.Type) nodeType {
+	return nodeType{
+		name:        t.Name,
+		description: t.Description,
+		new:         func(id string, p *platform) field.Node { return newExternal(id, t) },
+	}
+}
+
+// external is a node of a type from another package: an effect that runs its
+// Processor.
+type external struct {
+	*effect
+	mtx  sync.Mutex // so keys don't arrive during Process
+	proc node.Processor
+}
+
+// externalPlayer is an external node that can be played.
+type externalPlayer struct{ *external }
+
+// externalParams are an external node's params, as package node has them.
+type externalParams struct{ *params }
+
+func (p externalParams) Float(name string) float64 { return p.float(name) }
+
+func newExternal(id string, t node.Type) field.Node {
+	ps := []*param{}
+	for _, p := range t.Params {
+		ps = append(ps, floatP(p.Name, p.Min, p.Max, p.Default, p.Unit))
+	}
+	x := &external{proc: t.New(id)}
+	x.effect = newEffect(id, newParams(ps...), x)
+	if _, ok := x.proc.(node.Player); ok {
+		return externalPlayer{x}
+	}
+	return x
+}
+
+func (x *external) process(in []float32) []float32 {
+	x.mtx.Lock()
+	defer x.mtx.Unlock()
+	out := x.proc.Process(in, externalParams{x.params})
+	if len(out) != bufSz {
+		log.Printf("%s: got %d samples, want %d", x.ID(), len(out), bufSz)
+		return make([]float32, bufSz)
+	}
+	return out
+}
+
+func (x externalPlayer) keyDown(k keyEvent) {
+	x.mtx.Lock()
+	defer x.mtx.Unlock()
+	x.proc.(node.Player).KeyDown(k.midi, k.velocity)
+}
+
+func (x externalPlayer) keyUp(k keyEvent) {
+	x.mtx.Lock()
+	defer x.mtx.Unlock()
+	x.proc.(node.Player).KeyUp(k.midi)
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/peterbourgon/gmd/node"
+)
+
+// scaler is a node type from another package: it scales its input, and
+// counts keys if it's played.
+type scaler struct{ keys int }
+
+func (s *scaler) Process(in []float32, p node.Params) []float32 {
+	for i := range in {
+		in[i] *= float32(p.Float("gain"))
+	}
+	return in
+}
+
+type playedScaler struct{ scaler }
+
+func (s *playedScaler) KeyDown(key float64, velocity float32) { s.keys++ }
+func (s *playedScaler) KeyUp(key float64)                     { s.keys-- }
+
+func TestExternalNodeType(t *testing.T) {
+	if node.SampleRate != sRate || node.BufferSize != bufSz {
+		t.Fatalf("package node says %d Hz in %d samples, we run at %d in %d", node.SampleRate, node.BufferSize, sRate, bufSz)
+	}
+
+	gain := []node.Param{{Name: "gain", Min: 0, Max: 2, Default: 1}}
+	for _, typ := range []node.Type{
+		{Name: "scaler", Params: gain, New: func(string) node.Processor { return &scaler{} }},
+		{Name: "played", Params: gain, New: func(string) node.Processor { return &playedScaler{} }},
+	} {
+		node.Register(typ)
+		registerNodeType(externalType(typ)) // as init would have, had it been imported
+	}
+
+	n, err := newNode("scaler", "x", []string{"gain=0.5"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer n.(stopper).stop()
+	if out := n.(*external).process(constant(1)); out[0] != 0.5 {
+		t.Errorf("gain=0.5: expected 0.5, got %v", out[0])
+	}
+	if _, ok := n.(keyReceiver); ok {
+		t.Errorf("scaler: expected not to take keys")
+	}
+
+	p, err := newNode("played", "y", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.(stopper).stop()
+	r, ok := p.(keyReceiver)
+	if !ok {
+		t.Fatalf("played: expected to take keys")
+	}
+	r.keyDown(keyEvent{midi: 60, velocity: 1})
+	if keys := p.(externalPlayer).proc.(*playedScaler).keys; keys != 1 {
+		t.Errorf("played: expected 1 key down, got %d", keys)
+	}
+}
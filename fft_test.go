@@ -0,0 +1,40 @@
+package main
+
+import (
+	"math"
+	"math/cmplx"
+	"math/rand"
+	"testing"
+)
+
+func TestFFT(t *testing.T) {
+	for _, n := range []int{1, 2, 8, 64} {
+		x := make([]complex128, n)
+		for i := range x {
+			x[i] = complex(rand.Float64()*2-1, rand.Float64()*2-1)
+		}
+
+		// Against a plain DFT.
+		expected := make([]complex128, n)
+		for k := range expected {
+			for j, v := range x {
+				expected[k] += v * cmplx.Exp(complex(0, -2*math.Pi*float64(j*k)/float64(n)))
+			}
+		}
+		got := append([]complex128{}, x...)
+		fft(got, false)
+		for k := range got {
+			if cmplx.Abs(got[k]-expected[k]) > 1e-9 {
+				t.Errorf("n=%d bin %d: expected %v, got %v", n, k, expected[k], got[k])
+			}
+		}
+
+		// And back.
+		fft(got, true)
+		for i := range got {
+			if cmplx.Abs(got[i]-x[i]) > 1e-9 {
+				t.Errorf("n=%d inverse %d: expected %v, got %v", n, i, x[i], got[i])
+			}
+		}
+	}
+}
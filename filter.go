@@ -0,0 +1,107 @@
+package main
+
+import (
+	"log"
+	"math"
+	"strings"
+
+	"github.com/peterbourgon/field"
+)
+
+const keytrackRoot = 60 // the key at which key tracking leaves cutoff alone
+
+// filter is a resonant state-variable filter effect, with low-pass,
+// high-pass, band-pass and notch modes.
+//
+// Its cutoff can track keys: play it like a generator, or connect an arp to
+// it, and with keytrack 1 the cutoff moves an octave for every octave the
+// key is away from middle C.
+type filter struct {
+	*effect
+	ic1, ic2 float64 // integrator states
+}
+
+func init() {
+	registerNodeType(nodeType{
+		name:        "filter",
+		description: "resonant multimode filter effect, with key tracking",
+		new:         func(id string, p *platform) field.Node { return newFilter(id) },
+	})
+}
+
+func newFilter(id string) *filter {
+	f := &filter{}
+	f.effect = newEffect(id, newParams(
+		enumP("mode", "low", "low", "high", "band", "notch"),
+		floatP("cutoff", 20, 20000, 1000, "Hz"),
+		floatP("resonance", 0, 1, 0.2, ""),
+		floatP("keytrack", -1, 1, 0, ""),                   // octaves of cutoff per octave of key
+		readOnly(floatP("note", 0, 127, keytrackRoot, "")), // last key played
+	), f)
+	return f
+}
+
+// process runs the buffer through a topology-preserving transform SVF, which
+// stays stable as the cutoff is swept right up to Nyquist.
+func (f *filter) process(in []float32) []float32 {
+	var (
+		mode  = f.params.enum("mode")
+		track = f.params.float("keytrack") * (f.params.float("note") - keytrackRoot) / 12
+		hz    = math.Min(f.params.float("cutoff")*math.Pow(2, track), 0.45*sRate)
+		g     = math.Tan(math.Pi * math.Max(hz, 20) / sRate)
+		k     = 2 - 1.95*f.params.float("resonance") // damping; lower rings more
+		a1    = 1 / (1 + g*(g+k))
+		a2    = g * a1
+		a3    = g * a2
+	)
+	out := make([]float32, len(in))
+	for i, x := range in {
+		v0 := float64(x)
+		v3 := v0 - f.ic2
+		v1 := a1*f.ic1 + a2*v3
+		v2 := f.ic2 + a2*f.ic1 + a3*v3
+		f.ic1, f.ic2 = 2*v1-f.ic1, 2*v2-f.ic2
+
+		var y float64
+		switch mode {
+		case "low":
+			y = v2
+		case "high":
+			y = v0 - k*v1 - v2
+		case "band":
+			y = v1
+		case "notch":
+			y = v0 - k*v1
+		}
+		out[i] = float32(y)
+	}
+	return out
+}
+
+func (f *filter) parse(input string) {
+	toks := strings.Split(strings.TrimSpace(strings.ToLower(input)), " ")
+	switch toks[0] {
+	case "keydown", "kd", "down", "d":
+		k, err := parseKeyEvent(toks)
+		if err != nil {
+			log.Printf("%s: %s: %s", f.ID(), input, err)
+			return
+		}
+		f.keyDown(k)
+
+	case "keyup", "ku", "up", "u":
+		// The cutoff stays where the last key put it.
+
+	default:
+		f.effect.parse(input)
+	}
+}
+
+// keyDown implements the keyReceiver interface, for key tracking.
+func (f *filter) keyDown(k keyEvent) {
+	if k.midi > 0 {
+		f.params.report("note", k.midi)
+	}
+}
+
+func (f *filter) keyUp(k keyEvent) {}
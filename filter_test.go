@@ -0,0 +1,56 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestFilterModes(t *testing.T) {
+	// Gain at 100 Hz and 10 kHz, with the cutoff at 1 kHz.
+	for _, c := range []struct {
+		mode      string
+		low, high float64
+	}{
+		{"low", 1, 0},
+		{"high", 0, 1},
+		{"band", 0, 0},
+		{"notch", 1, 1},
+	} {
+		for _, probe := range []struct {
+			hz       float64
+			expected float64
+		}{
+			{100, c.low},
+			{10000, c.high},
+		} {
+			f := newFilter("filter")
+			f.params.set("mode", c.mode)
+			f.params.set("resonance", "0")
+			if got := gain(f, probe.hz); math.Abs(got-probe.expected) > 0.15 {
+				t.Errorf("%s at %v Hz: expected gain %.2f, got %.2f", c.mode, probe.hz, probe.expected, got)
+			}
+			f.stop()
+		}
+	}
+}
+
+// gain measures the steady-state peak gain of a processor for a sine.
+func gain(p processor, hz float64) float64 {
+	var phase float64
+	var peak float64
+	for n := 0; n < 20; n++ {
+		in := make([]float32, bufSz)
+		for i := range in {
+			in[i] = float32(math.Sin(2 * math.Pi * phase))
+			phase += hz / sRate
+		}
+		out := p.process(in)
+		if n < 10 {
+			continue // settling
+		}
+		for _, v := range out {
+			peak = math.Max(peak, math.Abs(float64(v)))
+		}
+	}
+	return peak
+}
@@ -1,7 +1,9 @@
 package main
 
 import (
+	"fmt"
 	"math"
+	"sync"
 )
 
 // A generatorFunction should define output for input [0..1]. We scale that to
@@ -17,30 +19,124 @@ import (
 type generatorFunction func(float32) float32
 
 func nextGeneratorFunctionValue(f generatorFunction, hz float32, phase *float32) float32 {
-	var val, p float32 = 0.0, 0.0
+	val := mirror(f, *phase)
+	advancePhase(phase, hz)
+	return val
+}
+
+// mirror is the value of a generatorFunction's full waveform at phase.
+func mirror(f generatorFunction, phase float32) float32 {
 	switch {
-	case *phase <= 0.25:
-		p = (*phase - 0.00) * 4
-		val = f(p) // no mirror
-	case *phase <= 0.50:
-		p = (*phase - 0.25) * 4
-		val = f(1 - p) // horizontal mirror
-	case *phase <= 0.75:
-		p = (*phase - 0.50) * 4
-		val = -f(p) // vertical mirror
-	case *phase <= 1.00:
-		p = (*phase - 0.75) * 4
-		val = -f(1 - p) // horizontal + vertical mirror
+	case phase <= 0.25:
+		return f((phase - 0.00) * 4) // no mirror
+	case phase <= 0.50:
+		return f(1 - (phase-0.25)*4) // horizontal mirror
+	case phase <= 0.75:
+		return -f((phase - 0.50) * 4) // vertical mirror
+	case phase <= 1.00:
+		return -f(1 - (phase-0.75)*4) // horizontal + vertical mirror
 	default:
 		panic("unreachable")
 	}
+}
+
+func advancePhase(phase *float32, hz float32) {
 	*phase += hz / sRate
-	if *phase > 1.0 {
-		*phase -= 1.0
+	*phase -= float32(math.Floor(float64(*phase))) // a full cycle ends just short of 1
+}
+
+// A cycleFunction defines a whole waveform directly, for phase [0..1], with
+// output in [-1..1]. Unlike a generatorFunction, it needn't be symmetric.
+// Width [0..1] varies the shape, for the functions that have one to vary.
+type cycleFunction func(phase, width float32) float32
+
+// A waveform is either kind of function. It's what oscillators play, and
+// what users select by name.
+type waveform struct {
+	quarter generatorFunction
+	cycle   cycleFunction
+}
+
+func (w waveform) at(phase, width float32) float32 {
+	if w.cycle != nil {
+		return w.cycle(phase, width)
 	}
+	return mirror(w.quarter, phase)
+}
+
+func nextWaveformValue(w waveform, width, hz float32, phase *float32) float32 {
+	val := w.at(*phase, width)
+	advancePhase(phase, hz)
 	return val
 }
 
+// maxDefinedWaveforms caps how many waveforms can be defined while running,
+// since each is another name in every generator's wave param.
+const maxDefinedWaveforms = 64
+
+// waveforms holds the selectable waveforms. The built-in ones are fixed, and
+// more can be defined while running, so access is locked.
+var waveforms = newWaveformRegistry([]string{
+	"sine", "triangle", "ramp", "saw", "square", "pulse",
+}, map[string]waveform{
+	"sine":     {quarter: sine},
+	"triangle": {quarter: saw}, // a rising quarter, mirrored
+	"ramp":     {cycle: ramp},
+	"saw":      {cycle: fallingSaw},
+	"square":   {cycle: fullSquare},
+	"pulse":    {cycle: pulse},
+})
+
+type waveformRegistry struct {
+	mtx     sync.RWMutex
+	names   []string // in the order defined, so enum params' indexes hold
+	byName  map[string]waveform
+	builtin map[string]bool
+}
+
+func newWaveformRegistry(names []string, byName map[string]waveform) *waveformRegistry {
+	r := &waveformRegistry{names: names, byName: byName, builtin: map[string]bool{}}
+	for _, name := range names {
+		r.builtin[name] = true
+	}
+	return r
+}
+
+// get returns the named waveform, or a sine if there's no such thing.
+func (r *waveformRegistry) get(name string) waveform {
+	r.mtx.RLock()
+	defer r.mtx.RUnlock()
+	if w, ok := r.byName[name]; ok {
+		return w
+	}
+	return waveform{quarter: sine}
+}
+
+// define adds a waveform, or replaces one that isn't built in. Past
+// maxDefinedWaveforms, only replacing works.
+func (r *waveformRegistry) define(name string, w waveform) error {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	if r.builtin[name] {
+		return fmt.Errorf("%s is built in", name)
+	}
+	if _, ok := r.byName[name]; !ok {
+		if len(r.names)-len(r.builtin) >= maxDefinedWaveforms {
+			return fmt.Errorf("already %d waveforms defined; redefine one instead", maxDefinedWaveforms)
+		}
+		r.names = append(r.names, name)
+	}
+	r.byName[name] = w
+	return nil
+}
+
+// waveformNames are the selectable waveforms, in the order they were defined.
+func waveformNames() []string {
+	waveforms.mtx.RLock()
+	defer waveforms.mtx.RUnlock()
+	return append([]string{}, waveforms.names...)
+}
+
 func saw(x float32) float32 {
 	return x
 }
@@ -57,31 +153,35 @@ func square(x float32) float32 {
 	return 0.0
 }
 
-func nextBuffer(f generatorFunction, hz float32, phase *float32) []float32 {
-	buf := make([]float32, bufSz)
-	for i := 0; i < bufSz; i++ {
-		buf[i] = nextGeneratorFunctionValue(f, hz, phase)
+func ramp(phase, _ float32) float32 {
+	return 2*phase - 1
+}
+
+func fallingSaw(phase, _ float32) float32 {
+	return 1 - 2*phase
+}
+
+func fullSquare(phase, _ float32) float32 {
+	return pulse(phase, 0.5)
+}
+
+func pulse(phase, width float32) float32 {
+	if phase < width {
+		return 1
 	}
-	return buf
+	return -1
 }
 
-func nextBufferMany(f generatorFunction, keys keySet) []float32 {
+func nextBuffer(f generatorFunction, hz float32, phase *float32) []float32 {
 	buf := make([]float32, bufSz)
-	for midi, phase := range keys {
-		for i := 0; i < bufSz; i++ {
-			buf[i] += nextGeneratorFunctionValue(f, midi2hz(midi), &phase)
-		}
-		keys[midi] = phase
+	for i := 0; i < bufSz; i++ {
+		buf[i] = nextGeneratorFunctionValue(f, hz, phase)
 	}
 	return buf
 }
 
-func midi2hz(midi int) float32 {
-	if midi < 20 {
-		midi = 20
-	}
-	if midi > 108 {
-		midi = 108
-	}
-	return float32((440.0 / 32) * math.Pow(2.0, (float64(midi)-9.0)/12.0))
+// midi2hz is the frequency of a (fractional) MIDI note in 12-TET with
+// A4 = 440 Hz. Generators use their tuning instead.
+func midi2hz(midi float64) float32 {
+	return equalTemperament.hz(midi)
 }
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"fmt"
 	"math"
 	"testing"
 )
@@ -14,12 +15,84 @@ func TestMidi2hz(t *testing.T) {
 		70: 466.16,  // Bb4
 		96: 2093.00, // C7
 	} {
-		if got := midi2hz(midi); !cmpFloat32(got, expected, 0.01) {
+		if got := midi2hz(float64(midi)); !cmpFloat32(got, expected, 0.01) {
 			t.Errorf("%d: expected %.4f, got %.4f", midi, expected, got)
 		}
 	}
 }
 
+func TestWaveforms(t *testing.T) {
+	for _, c := range []struct {
+		wave     string
+		phase    float32
+		width    float32
+		expected float32
+	}{
+		{"sine", 0.00, 0.5, 0.0},
+		{"sine", 0.25, 0.5, 1.0},
+		{"sine", 0.50, 0.5, 0.0},
+		{"sine", 0.75, 0.5, -1.0},
+		{"triangle", 0.125, 0.5, 0.5},
+		{"triangle", 0.25, 0.5, 1.0},
+		{"triangle", 0.625, 0.5, -0.5},
+		{"ramp", 0.00, 0.5, -1.0},
+		{"ramp", 0.25, 0.5, -0.5},
+		{"ramp", 0.75, 0.5, 0.5},
+		{"saw", 0.00, 0.5, 1.0},
+		{"saw", 0.75, 0.5, -0.5},
+		{"square", 0.25, 0.1, 1.0},
+		{"square", 0.75, 0.1, -1.0},
+		{"pulse", 0.05, 0.1, 1.0},
+		{"pulse", 0.25, 0.1, -1.0},
+		{"pulse", 0.85, 0.9, 1.0},
+		{"pulse", 0.95, 0.9, -1.0},
+	} {
+		if got := waveforms.get(c.wave).at(c.phase, c.width); !cmpFloat32(got, c.expected, 0.0001) {
+			t.Errorf("%s at %.3f (width %.2f): expected %.4f, got %.4f", c.wave, c.phase, c.width, c.expected, got)
+		}
+	}
+}
+
+func TestNextWaveformValue(t *testing.T) {
+	// A ramp at a quarter of the sample rate steps through four values, then
+	// wraps.
+	var phase float32
+	for i, expected := range []float32{-1, -0.5, 0, 0.5, -1} {
+		if got := nextWaveformValue(waveforms.get("ramp"), 0.5, sRate/4, &phase); !cmpFloat32(got, expected, 0.0001) {
+			t.Errorf("%d: expected %.4f, got %.4f", i, expected, got)
+		}
+	}
+}
+
 func cmpFloat32(f, expected, tolerance float32) bool {
 	return math.Abs(float64(f-expected)) < float64(tolerance)
 }
+
+func TestAdvancePhase(t *testing.T) {
+	// However far past a cycle a step goes, the phase wraps back into it.
+	for _, hz := range []float32{sRate - 1, sRate, 3.5 * sRate, -sRate / 4} {
+		phase := float32(0.5)
+		advancePhase(&phase, hz)
+		if phase < 0 || phase >= 1 {
+			t.Errorf("%v Hz: expected a phase in [0, 1), got %v", hz, phase)
+		}
+	}
+}
+
+func TestDefineWaveformLimit(t *testing.T) {
+	r := newWaveformRegistry([]string{"sine"}, map[string]waveform{"sine": {quarter: sine}})
+	for i := 0; i < maxDefinedWaveforms; i++ {
+		if err := r.define(fmt.Sprintf("w%d", i), waveform{cycle: ramp}); err != nil {
+			t.Fatalf("w%d: %s", i, err)
+		}
+	}
+	if err := r.define("one-too-many", waveform{cycle: ramp}); err == nil {
+		t.Errorf("expected an error past %d waveforms", maxDefinedWaveforms)
+	}
+	if err := r.define("w0", waveform{cycle: pulse}); err != nil {
+		t.Errorf("redefining w0: %s", err)
+	}
+	if err := r.define("sine", waveform{cycle: ramp}); err == nil {
+		t.Errorf("expected an error redefining a built-in")
+	}
+}
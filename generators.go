@@ -3,34 +3,79 @@ package main
 import (
 	"fmt"
 	"log"
+	"math"
 	"strconv"
 	"strings"
 
 	"github.com/peterbourgon/field"
 )
 
+// demoGenerator plays oscillators of a chosen waveform behind an attack/release
+// envelope. It's polyphonic by default, up to some voices, and in mono mode
+// plays a single voice which can glide between keys.
 type demoGenerator struct {
 	id            string
+	params        *params
 	keyDownEvents chan keyEvent
 	keyUpEvents   chan keyEvent
-	keysDown      keySet // MIDI keys
+	voices        map[float64]*voice // poly mode, by MIDI key
+	mono          *voice             // mono mode
+	held          []float64          // mono mode, keys down in the order pressed
+	monoMode      bool               // what voices and held are set up for
+	started       uint64             // voices started, to order them for stealing
+	vibratoPhase  float64            // 0..1
+	tuning        nodeTuning
+	retunes       chan retuneRequest
 	connects      chan connectRequest
 	disconnects   chan string
-	connected     string
-	output        chan []float32
+	levels        chan levelRequest
+	outputs       outputs
 	quit          chan chan struct{}
 }
 
+func init() {
+	registerNodeType(nodeType{
+		name:        "demo",
+		aliases:     []string{"synth"},
+		description: "polyphonic oscillator synth, with unison, glide and vibrato",
+		new:         func(id string, p *platform) field.Node { return newDemoGenerator(id) },
+	})
+}
+
 func newDemoGenerator(id string) *demoGenerator {
 	g := &demoGenerator{
-		id:            id,
-		keysDown:      keySet{},
+		id: id,
+		params: newParams(
+			enumFuncP("wave", "sine", waveformNames),
+			intP("voices", 1, maxVoices, 16),     // in poly mode
+			floatP("width", 0.01, 0.99, 0.5, ""), // pulse width, for pulse
+			intP("unison", 1, maxUnison, 1),
+			floatP("detune", 0, 1200, 0, "cents"),
+			floatP("attack", 0, 10000, 0, "ms"),
+			floatP("release", 0, 10000, 0, "ms"),
+			boolP("mono", false),
+			enumP("priority", "last", "last", "low", "high"),
+			boolP("legato", true),
+			floatP("glide", 0, 10000, 0, "ms"),
+			floatP("bend", -1, 1, 0, ""),
+			floatP("bendrange", 0, 48, 2, "semitones"),
+			floatP("pitch", -48, 48, 0, "semitones"),
+			floatP("vibrato", 0, 1200, 0, "cents"),
+			floatP("vibratorate", 0, 100, 5, "Hz"),
+		),
 		keyDownEvents: make(chan keyEvent),
 		keyUpEvents:   make(chan keyEvent),
+		voices:        map[float64]*voice{},
+		mono:          nil,
+		held:          []float64{},
+		monoMode:      false,
+		vibratoPhase:  0.0,
+		tuning:        nodeTuning{},
+		retunes:       make(chan retuneRequest),
 		connects:      make(chan connectRequest),
 		disconnects:   make(chan string),
-		connected:     "",
-		output:        nil,
+		levels:        make(chan levelRequest),
+		outputs:       outputs{},
 		quit:          make(chan chan struct{}),
 	}
 	go g.loop()
@@ -48,58 +93,213 @@ func (g *demoGenerator) loop() {
 	defer log.Printf("%s: done", g.ID())
 
 	for {
+		if g.outputs.idle() {
+			g.outputs.load(g.nextBuffer())
+		}
+		c, buf := g.outputs.next()
+
 		select {
-		case g.output <- nextBufferMany(sine, g.keysDown):
+		case c <- buf:
 			//log.Printf("%s ♪", g.ID())
-			break
+			g.outputs.sent()
+
+		case <-g.outputs.expired():
+			g.outputs.skip()
 
 		case k := <-g.keyDownEvents:
-			log.Printf("%s: press %d", g.ID(), k.midi)
-			g.keysDown.add(k.midi) // TODO velocity
-			log.Printf("%s: keys down %v", g.ID(), g.keysDown)
+			log.Printf("%s: press %v", g.ID(), k.midi)
+			g.press(k.midi) // TODO velocity
 
 		case k := <-g.keyUpEvents:
-			log.Printf("%s: lift %d", g.ID(), k.midi)
+			log.Printf("%s: lift %v", g.ID(), k.midi)
 			if k.midi == 0 {
-				g.keysDown = keySet{} // reset
-			} else {
-				g.keysDown.remove(k.midi)
+				g.reset()
+				continue
 			}
-			log.Printf("%s: keys down %v", g.ID(), g.keysDown)
+			g.lift(k.midi)
+
+		case r := <-g.retunes:
+			r.e <- g.tuning.apply(r.toks)
 
 		case r := <-g.connects:
-			if g.output != nil {
-				r.e <- fmt.Errorf("%s already connected to %s", g.ID(), g.connected)
+			if err := g.outputs.connect(g.ID(), r.r); err != nil {
+				r.e <- fmt.Errorf("%s %s", g.ID(), err)
 				continue
 			}
-			g.connected = r.r.ID()
-			g.output = make(chan []float32, 1)
-			r.r.receive(g.output)
 			r.e <- nil
 			log.Printf("%s → %s", g.ID(), r.r.ID())
 
 		case id := <-g.disconnects:
-			if g.output == nil {
-				log.Printf("%s: disconnect, but not connected", g.ID())
+			if err := g.outputs.disconnect(id); err != nil {
+				log.Printf("%s: disconnect: %s (bug in field)", g.ID(), err)
 				continue
 			}
-			if g.connected != id {
-				log.Printf("%s: connected to %s, not %s (bug in field)", g.ID(), g.connected, id)
-				continue
-			}
-			g.connected = ""
-			close(g.output)
-			g.output = nil
 			log.Printf("%s ✕ %s", g.ID(), id)
 
+		case r := <-g.levels:
+			r.e <- g.outputs.setLevel(r.id, r.level)
+
 		case q := <-g.quit:
+			g.outputs.closeAll()
 			close(q)
 			return
 		}
 	}
 }
 
+func (g *demoGenerator) nextBuffer() []float32 {
+	g.checkMode()
+	buf, t, offsets, p := make([]float32, bufSz), g.tuning.get(), g.pitchOffsets(), g.voiceParams()
+	for key, v := range g.voices {
+		if !v.render(buf, p, t, offsets) {
+			delete(g.voices, key)
+		}
+	}
+	if g.mono != nil && !g.mono.render(buf, p, t, offsets) {
+		g.mono = nil
+	}
+	return buf
+}
+
+func (g *demoGenerator) voiceParams() voiceParams {
+	return voiceParams{
+		wave:    waveforms.get(g.params.enum("wave")),
+		width:   float32(g.params.float("width")),
+		unison:  g.params.int("unison"),
+		detune:  float32(g.params.float("detune")),
+		attack:  g.params.float("attack") / 1000,
+		release: g.params.float("release") / 1000,
+	}
+}
+
+// pitchOffsets are the modulation, bend and vibrato for each sample of the
+// next buffer, in semitones. Vibrato moves every sample, so it's smooth at
+// any rate.
+func (g *demoGenerator) pitchOffsets() []float64 {
+	var (
+		offsets = make([]float64, bufSz)
+		base    = g.params.float("pitch") + g.params.float("bend")*g.params.float("bendrange")
+		depth   = g.params.float("vibrato") / 100
+		step    = g.params.float("vibratorate") / sRate
+	)
+	for i := range offsets {
+		offsets[i] = base + depth*math.Sin(2*math.Pi*g.vibratoPhase)
+		g.vibratoPhase += step
+	}
+	g.vibratoPhase -= math.Floor(g.vibratoPhase)
+	return offsets
+}
+
+// checkMode drops every voice when switching between poly and mono.
+func (g *demoGenerator) checkMode() {
+	if mono := g.params.bool("mono"); mono != g.monoMode {
+		g.reset()
+		g.monoMode = mono
+	}
+}
+
+func (g *demoGenerator) reset() {
+	g.voices, g.mono, g.held = map[float64]*voice{}, nil, []float64{}
+}
+
+func (g *demoGenerator) press(key float64) {
+	g.checkMode()
+	if !g.monoMode {
+		if v, ok := g.voices[key]; ok {
+			v.held = true // still releasing: pick it up from where it is
+			return
+		}
+		for len(g.voices) >= g.params.int("voices") {
+			g.steal()
+		}
+		g.voices[key] = g.newVoice(key)
+		return
+	}
+
+	glide := g.params.float("glide") / 1000
+	legato := g.params.bool("legato") && len(g.held) > 0
+	g.held = append(removeKey(g.held, key), key)
+	target := g.monoKey()
+	switch {
+	case g.mono == nil:
+		g.mono = g.newVoice(target)
+	case g.mono.held && target == g.mono.target:
+		return // the new key doesn't have priority
+	case legato:
+		g.mono.glideTo(target, glide)
+	default:
+		g.mono.held, g.mono.level = true, 0.0 // retrigger
+		g.mono.glideTo(target, glide)
+	}
+}
+
+func (g *demoGenerator) newVoice(key float64) *voice {
+	v := newVoice(key, g.params.int("unison"))
+	g.started++
+	v.started = g.started
+	return v
+}
+
+// steal drops a poly voice to make room for another: the quietest that's been
+// released, or if they're all held, the oldest.
+func (g *demoGenerator) steal() {
+	var (
+		victim *voice
+		key    float64
+	)
+	for k, v := range g.voices {
+		switch {
+		case victim == nil,
+			victim.held && !v.held,
+			!victim.held && !v.held && v.level < victim.level,
+			victim.held && v.held && v.started < victim.started:
+			victim, key = v, k
+		}
+	}
+	delete(g.voices, key)
+}
+
+func (g *demoGenerator) lift(key float64) {
+	g.checkMode()
+	if !g.monoMode {
+		if v, ok := g.voices[key]; ok {
+			v.held = false
+		}
+		return
+	}
+
+	g.held = removeKey(g.held, key)
+	if g.mono == nil {
+		return
+	}
+	if len(g.held) <= 0 {
+		g.mono.held = false
+		return
+	}
+	if target := g.monoKey(); target != g.mono.target {
+		if !g.params.bool("legato") {
+			g.mono.level = 0.0 // retrigger
+		}
+		g.mono.glideTo(target, g.params.float("glide")/1000)
+	}
+}
+
+// monoKey picks the held key that mono mode should play.
+func (g *demoGenerator) monoKey() float64 {
+	key, priority := g.held[len(g.held)-1], g.params.enum("priority")
+	for _, k := range g.held {
+		switch {
+		case priority == "low" && k < key:
+			key = k
+		case priority == "high" && k > key:
+			key = k
+		}
+	}
+	return key
+}
+
 func (g *demoGenerator) parse(input string) {
+	raw := strings.Split(strings.TrimSpace(input), " ") // for file names
 	input = strings.TrimSpace(strings.ToLower(input))
 	toks := strings.Split(input, " ")
 	if len(toks) <= 0 {
@@ -109,58 +309,66 @@ func (g *demoGenerator) parse(input string) {
 
 	switch toks[0] {
 	case "keydown", "kd", "down", "d":
-		if len(toks) < 2 {
-			log.Printf("%s: %s: not enough", g.ID(), input)
+		k, err := parseKeyEvent(toks)
+		if err != nil {
+			log.Printf("%s: %s: %s", g.ID(), input, err)
 			return
 		}
+		g.keyDown(k)
 
-		midi, err := strconv.ParseInt(toks[1], 10, 32)
+	case "keyup", "ku", "up", "u":
+		k, err := parseKeyEvent(toks)
 		if err != nil {
 			log.Printf("%s: %s: %s", g.ID(), input, err)
 			return
 		}
+		g.keyUp(k)
 
-		velocity := 1.0
-		if len(toks) >= 3 {
-			velocity, err = strconv.ParseFloat(toks[2], 32)
-			if err != nil {
-				log.Printf("%s: %s: %s", g.ID(), input, err)
-				return
-			}
-		}
+	case "reset", "release":
+		// Lets go of every key, whatever follows, as release always has. The
+		// release time is a param: set <id> release <ms>.
+		g.keyUp(keyEvent{})
 
-		g.keyDownEvents <- keyEvent{int(midi), float32(velocity)}
+	case "mono", "poly":
+		setParam(g, []string{"mono", boolString(toks[0] == "mono")})
 
-	case "keyup", "ku", "up", "u":
-		if len(toks) < 2 {
-			log.Printf("%s: %s: not enough", g.ID(), input)
-			return
-		}
+	case "portamento":
+		setParam(g, append([]string{"glide"}, toks[1:]...))
 
-		midi, err := strconv.ParseInt(toks[1], 10, 32)
-		if err != nil {
-			log.Printf("%s: %s: %s", g.ID(), input, err)
+	case "vibrato", "vib":
+		if len(toks) >= 3 && !setParam(g, []string{"vibratorate", toks[2]}) {
 			return
 		}
+		setParam(g, append([]string{"vibrato"}, toks[1:]...))
 
-		velocity := 1.0
-		if len(toks) >= 3 {
-			velocity, err = strconv.ParseFloat(toks[2], 32)
-			if err != nil {
-				log.Printf("%s: %s: %s", g.ID(), input, err)
-				return
-			}
-		}
+	case "level", "lvl":
+		requestLevel(g.ID(), g.levels, toks)
 
-		g.keyUpEvents <- keyEvent{int(midi), float32(velocity)}
+	case "tuning", "tune":
+		requestRetune(g.ID(), g.retunes, raw)
 
-	case "reset", "release":
-		g.keyUpEvents <- keyEvent{}
+	default:
+		if !g.params.has(toks[0]) {
+			log.Printf("%s: %s: aroo", g.ID(), input)
+			return
+		}
+		setParam(g, toks)
 	}
 }
 
 func (g *demoGenerator) ID() string { return g.id }
 
+func (g *demoGenerator) parameters() *params { return g.params }
+
+func (g *demoGenerator) keyDown(k keyEvent) { g.keyDownEvents <- k }
+func (g *demoGenerator) keyUp(k keyEvent)   { g.keyUpEvents <- k }
+
+// modulate implements the modulatable interface. Pitch is in semitones, on
+// top of bend and vibrato.
+func (g *demoGenerator) modulate(param string, value float64) error {
+	return g.params.modulate(param, value)
+}
+
 func (g *demoGenerator) Connect(n field.Node) error {
 	r, ok := n.(audioReceiver)
 	if !ok {
@@ -180,25 +388,119 @@ func (g *demoGenerator) Disconnect(n field.Node) {
 }
 
 func (g *demoGenerator) Connection(n field.Node) error {
-	log.Printf("%s: Connection(%s): no", g.ID(), n.ID())
-	return errNo
+	return controlConnection(g, n)
 }
 
 func (g *demoGenerator) Disconnection(n field.Node) {
 	log.Printf("%s: Disonnection(%s): ignored", g.ID(), n.ID())
 }
 
+// controller is a node that plays or modulates others by calling them, like
+// an lfo or an arp, rather than sending them audio. controls is whether it
+// can drive n.
+type controller interface {
+	controls(n field.Node) bool
+}
+
+// controlConnection accepts connections to a generator from controllers that
+// can drive it.
+func controlConnection(to, from field.Node) error {
+	if c, ok := from.(controller); ok && c.controls(to) {
+		return nil // it calls our modulate(), or keyDown() and keyUp()
+	}
+	log.Printf("%s: Connection(%s): no", to.ID(), from.ID())
+	return errNo
+}
+
+// requestLevel handles "level <receiver> <level>" for a node's loop.
+func requestLevel(id string, levels chan levelRequest, toks []string) {
+	if len(toks) < 3 {
+		log.Printf("%s: %s: need receiver and level", id, toks[0])
+		return
+	}
+	level, err := strconv.ParseFloat(toks[2], 32)
+	if err != nil {
+		log.Printf("%s: %s: %s", id, toks[0], err)
+		return
+	}
+	req := levelRequest{toks[1], float32(level), make(chan error)}
+	levels <- req
+	if err := <-req.e; err != nil {
+		log.Printf("%s: %s: %s", id, toks[0], err)
+		return
+	}
+	log.Printf("%s: level %s %.2f: OK", id, toks[1], level)
+}
+
+// requestRetune handles "tuning ..." for a node's loop. raw is the command
+// as typed, since it may name a file.
+func requestRetune(id string, retunes chan retuneRequest, raw []string) {
+	if len(raw) < 2 {
+		log.Printf("%s: %s: not enough", id, raw[0])
+		return
+	}
+	req := retuneRequest{raw[1:], make(chan error)}
+	retunes <- req
+	if err := <-req.e; err != nil {
+		log.Printf("%s: %s: %s", id, strings.Join(raw, " "), err)
+		return
+	}
+	log.Printf("%s: %s: OK", id, strings.Join(raw, " "))
+}
+
 type connectRequest struct {
 	r audioReceiver
 	e chan error
 }
 
+// levelRequest sets the send level of one of a node's outputs.
+type levelRequest struct {
+	id    string
+	level float32
+	e     chan error
+}
+
+// retuneRequest carries a tuning command (see parseTuning) to a generator,
+// or "global" to go back to following the global tuning.
+type retuneRequest struct {
+	toks []string
+	e    chan error
+}
+
 type keyEvent struct {
-	midi     int     // key
+	midi     float64 // key, fractional for microtones
 	velocity float32 // 0..1
 }
 
-type keySet map[int]float32 // MIDI key: phase
+// parseKeyEvent parses the arguments of a keydown or keyup command: a MIDI
+// key, and an optional velocity.
+func parseKeyEvent(toks []string) (keyEvent, error) {
+	if len(toks) < 2 {
+		return keyEvent{}, fmt.Errorf("not enough")
+	}
+
+	midi, err := strconv.ParseFloat(toks[1], 64)
+	if err != nil {
+		return keyEvent{}, err
+	}
+
+	velocity := 1.0
+	if len(toks) >= 3 {
+		velocity, err = strconv.ParseFloat(toks[2], 32)
+		if err != nil {
+			return keyEvent{}, err
+		}
+	}
+
+	return keyEvent{midi, float32(velocity)}, nil
+}
 
-func (s keySet) add(i int)    { s[i] = 0.0 }
-func (s keySet) remove(i int) { delete(s, i) }
+func removeKey(keys []float64, key float64) []float64 {
+	out := keys[:0]
+	for _, k := range keys {
+		if k != key {
+			out = append(out, k)
+		}
+	}
+	return out
+}
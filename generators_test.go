@@ -0,0 +1,232 @@
+package main
+
+import (
+	"math"
+	"testing"
+
+	"github.com/peterbourgon/field"
+)
+
+// sequencer is a controller that isn't one of ours.
+type sequencer struct{ field.Node }
+
+func (s sequencer) ID() string                 { return "seq" }
+func (s sequencer) controls(n field.Node) bool { _, ok := n.(keyReceiver); return ok }
+
+func TestControlConnection(t *testing.T) {
+	c := newClock(120)
+	defer c.stop()
+	l, a := newLFO("lfo", c), newArp("arp", c)
+	defer l.stop()
+	defer a.stop()
+	g, e := newDemoGenerator("synth"), newFilter("filter")
+	defer g.stop()
+	defer e.stop()
+
+	for _, x := range []struct {
+		to, from field.Node
+		ok       bool
+	}{
+		{g, l, true},
+		{g, a, true},
+		{g, sequencer{}, true},
+		{g, e, false},
+	} {
+		if err := controlConnection(x.to, x.from); (err == nil) != x.ok {
+			t.Errorf("%s from %s: expected ok %v, got %v", x.to.ID(), x.from.ID(), x.ok, err)
+		}
+	}
+}
+
+func TestVoiceStealing(t *testing.T) {
+	g := newDemoGenerator("synth")
+	g.stop() // so we can drive it
+	g.params.set("voices", "3")
+	g.params.set("release", "1000")
+
+	for _, x := range []struct {
+		input    string
+		key      float64
+		expected []float64 // sounding, in any order
+	}{
+		{"press", 60, []float64{60}},
+		{"press", 62, []float64{60, 62}},
+		{"press", 64, []float64{60, 62, 64}},
+		{"press", 65, []float64{62, 64, 65}}, // the oldest held
+		{"lift", 64, []float64{62, 64, 65}},
+		{"press", 67, []float64{62, 65, 67}}, // the released one first
+		{"press", 62, []float64{62, 65, 67}}, // already sounding
+	} {
+		if x.input == "press" {
+			g.press(x.key)
+		} else {
+			g.lift(x.key)
+		}
+		if len(g.voices) != len(x.expected) {
+			t.Errorf("%s %v: expected %v, got %d voices", x.input, x.key, x.expected, len(g.voices))
+		}
+		for _, k := range x.expected {
+			if _, ok := g.voices[k]; !ok {
+				t.Errorf("%s %v: expected %v sounding", x.input, x.key, k)
+			}
+		}
+	}
+}
+
+func TestReleaseCommand(t *testing.T) {
+	g := newDemoGenerator("synth")
+	g.parse("d 60")
+	g.parse("d 64")
+	g.parse("release 0")
+	g.stop()
+	if len(g.voices) != 0 {
+		t.Errorf("expected release 0 to let go of every key, got %d voices", len(g.voices))
+	}
+	if got, _ := g.params.get("release"); got != "0 ms" {
+		t.Errorf("expected release 0 to leave the release time alone, got %s", got)
+	}
+}
+
+func TestMonoPriority(t *testing.T) {
+	for _, x := range []struct {
+		priority string
+		presses  []float64
+		lifts    []float64
+		expected float64
+	}{
+		{"last", []float64{60, 67, 64}, nil, 64},
+		{"low", []float64{60, 67, 64}, nil, 60},
+		{"high", []float64{60, 67, 64}, nil, 67},
+		{"last", []float64{60, 67, 64}, []float64{64}, 67},
+		{"low", []float64{60, 67, 64}, []float64{60}, 64},
+		{"high", []float64{60, 67, 64}, []float64{67, 60}, 64},
+	} {
+		g := newDemoGenerator("synth")
+		g.stop() // so we can drive it
+		g.params.set("mono", "on")
+		g.params.set("priority", x.priority)
+		for _, k := range x.presses {
+			g.press(k)
+		}
+		for _, k := range x.lifts {
+			g.lift(k)
+		}
+		if g.mono == nil || g.mono.target != x.expected {
+			t.Errorf("%s priority, %v down, %v up: expected %v, got %+v", x.priority, x.presses, x.lifts, x.expected, g.mono)
+		}
+	}
+}
+
+func TestMonoLegato(t *testing.T) {
+	for _, x := range []struct {
+		legato   string
+		expected float32 // level after the second key
+	}{
+		{"on", 1},  // carries on
+		{"off", 0}, // retriggers
+	} {
+		g := newDemoGenerator("synth")
+		g.stop() // so we can drive it
+		g.params.set("mono", "on")
+		g.params.set("legato", x.legato)
+		g.press(60)
+		g.mono.level = 1
+		g.press(64)
+		if g.mono.level != x.expected || g.mono.target != 64 {
+			t.Errorf("legato %s: expected level %v at 64, got %v at %v", x.legato, x.expected, g.mono.level, g.mono.target)
+		}
+	}
+}
+
+// renderKey plays key on a fresh synth with params for seconds.
+func renderKey(key, seconds float64, params ...string) []float32 {
+	g := newDemoGenerator("synth")
+	g.stop() // so we can drive it
+	for i := 0; i+1 < len(params); i += 2 {
+		g.params.set(params[i], params[i+1])
+	}
+	g.press(key)
+	buf := []float32{}
+	for len(buf) < int(seconds*sRate) {
+		buf = append(buf, g.nextBuffer()...)
+	}
+	return buf
+}
+
+func TestBend(t *testing.T) {
+	for _, x := range []struct {
+		bend, bendrange string
+		semitones       float64
+	}{
+		{"0", "2", 0},
+		{"0.5", "2", 1},
+		{"-1", "2", -2},
+		{"1", "12", 12},
+	} {
+		buf := renderKey(69, 0.2, "bend", x.bend, "bendrange", x.bendrange)
+		hz := 440 * math.Pow(2, x.semitones/12)
+		if m := magnitude(buf, hz); m < 0.9 {
+			t.Errorf("bend %s of %s: expected %.1f Hz, got %.3f of it", x.bend, x.bendrange, hz, m)
+		}
+	}
+}
+
+func TestVibrato(t *testing.T) {
+	// Frequency modulation spreads the note into sidebands, so the less of it
+	// there is left, the deeper the vibrato.
+	for _, x := range []struct {
+		depth, rate string
+		min, max    float64 // at 440 Hz
+	}{
+		{"0", "5", 0.99, 1.01},
+		{"100", "0", 0.99, 1.01},
+		{"10", "5", 0.8, 0.99},
+		{"100", "5", 0, 0.3},
+	} {
+		buf := renderKey(69, 1, "vibrato", x.depth, "vibratorate", x.rate)
+		if m := magnitude(buf, 440); m < x.min || m > x.max {
+			t.Errorf("%s cents at %s Hz: expected %v to %v of 440 Hz, got %.3f", x.depth, x.rate, x.min, x.max, m)
+		}
+		if m := magnitude(buf, 500); m > 0.05 {
+			t.Errorf("%s cents at %s Hz: expected nothing past a semitone, got %.3f at 500 Hz", x.depth, x.rate, m)
+		}
+	}
+}
+
+func TestExtremeTuning(t *testing.T) {
+	// The highest tuning there is, on the highest key, is still below
+	// Nyquist; every waveform plays it without its phase running away.
+	for _, wave := range waveformNames() {
+		g := newDemoGenerator("synth")
+		g.stop() // so we can drive it
+		for _, toks := range [][]string{{"a4", "20000"}, {"transpose", "48"}, {"cents", "1200"}} {
+			if err := g.tuning.apply(toks); err != nil {
+				t.Fatal(err)
+			}
+		}
+		g.params.set("wave", wave)
+		g.params.set("pitch", "48")
+		g.press(127)
+		for _, v := range g.nextBuffer() {
+			if math.IsNaN(float64(v)) || math.Abs(float64(v)) > 1.01 {
+				t.Fatalf("%s: expected a bounded signal, got %v", wave, v)
+			}
+		}
+	}
+}
+
+func TestFastVibrato(t *testing.T) {
+	// Past the buffer rate, vibrato still wobbles at its own rate: the
+	// sidebands are rate either side of the note.
+	buf := renderKey(69, 1, "vibrato", "50", "vibratorate", "40")
+	for _, hz := range []float64{400, 480} {
+		if m := magnitude(buf, hz); m < 0.1 {
+			t.Errorf("expected a sideband at %v Hz, got %.3f", hz, m)
+		}
+	}
+	for _, hz := range []float64{437, 443} {
+		if m := magnitude(buf, hz); m > 0.08 {
+			t.Errorf("expected nothing at %v Hz, got %.3f", hz, m)
+		}
+	}
+}
@@ -0,0 +1,361 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"math/rand"
+	"strings"
+
+	"github.com/peterbourgon/field"
+)
+
+const maxGrains = 256 // per generator; past this, new grains are dropped
+
+// grainGenerator plays a loaded sound as a cloud of short, overlapping,
+// windowed grains. Each held key sprays grains from around the position
+// param, at a playback rate set by the key relative to the root key.
+type grainGenerator struct {
+	id            string
+	params        *params
+	sample        *sample
+	keyDownEvents chan keyEvent
+	keyUpEvents   chan keyEvent
+	voices        map[float64]*grainVoice // by MIDI key
+	grains        []*grain
+	tuning        nodeTuning
+	loads         chan *sample
+	retunes       chan retuneRequest
+	connects      chan connectRequest
+	disconnects   chan string
+	levels        chan levelRequest
+	outputs       outputs
+	quit          chan chan struct{}
+}
+
+// grainVoice is a held (or releasing) key, spawning grains.
+type grainVoice struct {
+	key      float64
+	velocity float32
+	level    float32 // envelope
+	held     bool
+	due      float64 // samples until the next grain
+}
+
+// grain is one window's worth of playback from the sample.
+type grain struct {
+	pos    float64 // in the sample, in its own frames
+	rate   float64 // frames of the sample per output sample
+	length int     // output samples
+	age    int
+	amp    float32
+}
+
+func init() {
+	registerNodeType(nodeType{
+		name:        "grain",
+		description: "granular synthesis over a WAV file",
+		args:        []nodeArg{{name: "load", what: "<file>", apply: grainLoadArg}},
+		new:         func(id string, p *platform) field.Node { return newGrainGenerator(id) },
+	})
+}
+
+func grainLoadArg(n field.Node, filename string) error {
+	g, ok := n.(*grainGenerator)
+	if !ok {
+		return fmt.Errorf("%s isn't a grain", n.ID())
+	}
+	return g.load(filename)
+}
+
+func newGrainGenerator(id string) *grainGenerator {
+	g := &grainGenerator{
+		id: id,
+		params: newParams(
+			floatP("position", 0, 1, 0, ""),     // in the sample, 0 = start
+			floatP("spray", 0, 1, 0.05, ""),     // random spread around position
+			floatP("size", 5, 1000, 100, "ms"),  // of each grain
+			floatP("density", 1, 200, 20, "Hz"), // grains per second, per key
+			floatP("pitch", -24, 24, 0, "semitones"),
+			intP("root", 0, 127, 60), // the key that plays at the original rate
+			floatP("attack", 0, 10, 0.05, "s"),
+			floatP("release", 0, 10, 0.5, "s"),
+		),
+		keyDownEvents: make(chan keyEvent),
+		keyUpEvents:   make(chan keyEvent),
+		voices:        map[float64]*grainVoice{},
+		grains:        []*grain{},
+		tuning:        nodeTuning{},
+		loads:         make(chan *sample),
+		retunes:       make(chan retuneRequest),
+		connects:      make(chan connectRequest),
+		disconnects:   make(chan string),
+		levels:        make(chan levelRequest),
+		outputs:       outputs{},
+		quit:          make(chan chan struct{}),
+	}
+	go g.loop()
+	return g
+}
+
+func (g *grainGenerator) stop() {
+	q := make(chan struct{})
+	g.quit <- q
+	<-q
+}
+
+func (g *grainGenerator) loop() {
+	log.Printf("%s: started", g.ID())
+	defer log.Printf("%s: done", g.ID())
+
+	for {
+		if g.outputs.idle() {
+			g.outputs.load(g.nextBuffer())
+		}
+		c, buf := g.outputs.next()
+
+		select {
+		case c <- buf:
+			g.outputs.sent()
+
+		case <-g.outputs.expired():
+			g.outputs.skip()
+
+		case k := <-g.keyDownEvents:
+			if v, ok := g.voices[k.midi]; ok {
+				v.velocity, v.held = k.velocity, true // retrigger
+				continue
+			}
+			g.voices[k.midi] = &grainVoice{key: k.midi, velocity: k.velocity, held: true}
+
+		case k := <-g.keyUpEvents:
+			if k.midi == 0 {
+				g.voices = map[float64]*grainVoice{} // reset
+				g.grains = []*grain{}
+				continue
+			}
+			if v, ok := g.voices[k.midi]; ok {
+				v.held = false
+			}
+
+		case s := <-g.loads:
+			g.sample = s
+			g.grains = []*grain{} // they were reading the old one
+
+		case r := <-g.retunes:
+			r.e <- g.tuning.apply(r.toks)
+
+		case r := <-g.connects:
+			if err := g.outputs.connect(g.ID(), r.r); err != nil {
+				r.e <- fmt.Errorf("%s %s", g.ID(), err)
+				continue
+			}
+			r.e <- nil
+			log.Printf("%s → %s", g.ID(), r.r.ID())
+
+		case id := <-g.disconnects:
+			if err := g.outputs.disconnect(id); err != nil {
+				log.Printf("%s: disconnect: %s (bug in field)", g.ID(), err)
+				continue
+			}
+			log.Printf("%s ✕ %s", g.ID(), id)
+
+		case r := <-g.levels:
+			r.e <- g.outputs.setLevel(r.id, r.level)
+
+		case q := <-g.quit:
+			g.outputs.closeAll()
+			close(q)
+			return
+		}
+	}
+}
+
+func (g *grainGenerator) nextBuffer() []float32 {
+	buf := make([]float32, bufSz)
+	if g.sample == nil || len(g.sample.data) <= 0 {
+		return buf
+	}
+
+	var (
+		t       = g.tuning.get()
+		size    = g.params.float("size") / 1000 * sRate
+		density = g.params.float("density")
+		attack  = float32(1 / math.Max(g.params.float("attack")*sRate, 1))
+		release = float32(1 / math.Max(g.params.float("release")*sRate, 1))
+		pitch   = math.Pow(2, g.params.float("pitch")/12)
+		rootHz  = float64(t.hz(float64(g.params.int("root"))))
+
+		// Overlapping grains add up; keep the cloud near unity gain.
+		gain = float32(1 / math.Sqrt(math.Max(1, density*size/sRate)))
+	)
+
+	// Schedule grains, a buffer at a time.
+	for key, v := range g.voices {
+		hz := float64(t.hz(v.key))
+		if hz <= 0 || rootHz <= 0 {
+			delete(g.voices, key) // unmapped
+			continue
+		}
+		rate := hz / rootHz * pitch * g.sample.rate / sRate
+
+		for i := 0; i < bufSz; i++ {
+			if v.held && v.level < 1 {
+				v.level = float32(math.Min(1, float64(v.level+attack)))
+			} else if !v.held {
+				v.level -= release
+			}
+			if v.level <= 0 && !v.held {
+				delete(g.voices, key)
+				break
+			}
+			if v.due -= 1; v.due > 0 {
+				continue
+			}
+			v.due += sRate / density
+			if len(g.grains) >= maxGrains {
+				continue
+			}
+			g.grains = append(g.grains, &grain{
+				pos:    g.grainStart(),
+				rate:   rate,
+				length: int(size),
+				age:    -i, // starts partway into the buffer
+				amp:    gain * v.velocity * v.level,
+			})
+		}
+	}
+
+	// Render them.
+	live := g.grains[:0]
+	for _, gr := range g.grains {
+		if gr.render(buf, g.sample) {
+			live = append(live, gr)
+		}
+	}
+	g.grains = live
+	return buf
+}
+
+// grainStart picks a frame of the sample around the position param.
+func (g *grainGenerator) grainStart() float64 {
+	pos := g.params.float("position") + g.params.float("spray")*(2*rand.Float64()-1)
+	return pos * float64(len(g.sample.data))
+}
+
+// render adds the grain's next bufSz samples to buf, under a Hann window.
+// It returns false once the grain is done.
+func (gr *grain) render(buf []float32, s *sample) bool {
+	for i := range buf {
+		if gr.age < 0 {
+			gr.age++
+			continue
+		}
+		if gr.age >= gr.length {
+			return false
+		}
+		w := 0.5 - 0.5*math.Cos(2*math.Pi*float64(gr.age)/float64(gr.length))
+		buf[i] += gr.amp * float32(w) * s.at(gr.pos)
+		gr.pos += gr.rate
+		gr.age++
+	}
+	return gr.age < gr.length
+}
+
+// load loads a sound for the loop to play.
+func (g *grainGenerator) load(filename string) error {
+	s, err := loadWAV(filename)
+	if err != nil {
+		return err
+	}
+	g.loads <- s
+	log.Printf("%s: loaded %s (%.2fs at %v Hz)", g.ID(), filename, float64(len(s.data))/s.rate, s.rate)
+	return nil
+}
+
+func (g *grainGenerator) parse(input string) {
+	raw := strings.Split(strings.TrimSpace(input), " ") // for file names
+	input = strings.TrimSpace(strings.ToLower(input))
+	toks := strings.Split(input, " ")
+	if len(toks) <= 0 {
+		log.Printf("%s: parse empty", g.ID())
+		return
+	}
+
+	switch toks[0] {
+	case "keydown", "kd", "down", "d":
+		k, err := parseKeyEvent(toks)
+		if err != nil {
+			log.Printf("%s: %s: %s", g.ID(), input, err)
+			return
+		}
+		g.keyDown(k)
+
+	case "keyup", "ku", "up", "u":
+		k, err := parseKeyEvent(toks)
+		if err != nil {
+			log.Printf("%s: %s: %s", g.ID(), input, err)
+			return
+		}
+		g.keyUp(k)
+
+	case "reset":
+		g.keyUp(keyEvent{})
+
+	case "load":
+		if len(raw) < 2 {
+			log.Printf("%s: %s: need a file", g.ID(), input)
+			return
+		}
+		if err := g.load(strings.Join(raw[1:], " ")); err != nil {
+			log.Printf("%s: load: %s", g.ID(), err)
+		}
+
+	case "level", "lvl":
+		requestLevel(g.ID(), g.levels, toks)
+
+	case "tuning", "tune":
+		requestRetune(g.ID(), g.retunes, raw)
+
+	default:
+		if !g.params.has(toks[0]) {
+			log.Printf("%s: %s: aroo", g.ID(), input)
+			return
+		}
+		setParam(g, toks)
+	}
+}
+
+func (g *grainGenerator) ID() string          { return g.id }
+func (g *grainGenerator) parameters() *params { return g.params }
+
+func (g *grainGenerator) modulate(param string, value float64) error {
+	return g.params.modulate(param, value)
+}
+
+func (g *grainGenerator) keyDown(k keyEvent) { g.keyDownEvents <- k }
+func (g *grainGenerator) keyUp(k keyEvent)   { g.keyUpEvents <- k }
+
+func (g *grainGenerator) Connect(n field.Node) error {
+	r, ok := n.(audioReceiver)
+	if !ok {
+		return fmt.Errorf("%s not audioReceiver", n.ID())
+	}
+	req := connectRequest{r, make(chan error)}
+	g.connects <- req
+	return <-req.e
+}
+
+func (g *grainGenerator) Disconnect(n field.Node) {
+	if _, ok := n.(audioReceiver); !ok {
+		log.Printf("%s not audioReceiver", n.ID())
+		return
+	}
+	g.disconnects <- n.ID()
+}
+
+func (g *grainGenerator) Connection(n field.Node) error {
+	return controlConnection(g, n)
+}
+
+func (g *grainGenerator) Disconnection(n field.Node) {}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"math"
+	"strconv"
+	"testing"
+)
+
+// grainTest is a stopped grain generator over a second of the sound f makes,
+// with the root key held and params set.
+func grainTest(f func(i int) float32, params ...string) *grainGenerator {
+	g := newGrainGenerator("grain")
+	g.stop() // so we can drive it
+	g.sample = &sample{data: make([]float32, sRate), rate: sRate}
+	for i := range g.sample.data {
+		g.sample.data[i] = f(i)
+	}
+	g.params.set("attack", "0")
+	for i := 0; i+1 < len(params); i += 2 {
+		g.params.set(params[i], params[i+1])
+	}
+	root := float64(g.params.int("root"))
+	g.voices[root] = &grainVoice{key: root, velocity: 1, held: true}
+	return g
+}
+
+// renderGrains runs g for seconds.
+func renderGrains(g *grainGenerator, seconds float64) []float32 {
+	buf := []float32{}
+	for len(buf) < int(seconds*sRate) {
+		buf = append(buf, g.nextBuffer()...)
+	}
+	return buf
+}
+
+func TestGrainDensity(t *testing.T) {
+	for _, density := range []float64{1, 20, 100} {
+		// Grains a second long, so none finish.
+		g := grainTest(func(int) float32 { return 1 }, "size", "1000", "density", strconv.FormatFloat(density, 'f', -1, 64))
+		buf := renderGrains(g, 0.5)
+		expected := int(math.Ceil(float64(len(buf)) * density / sRate))
+		if len(g.grains) != expected {
+			t.Errorf("%v Hz: expected %d grains in %d samples, got %d", density, expected, len(buf), len(g.grains))
+		}
+	}
+}
+
+func TestGrainWindow(t *testing.T) {
+	// One grain of 100ms over a constant sound is just its window.
+	g := grainTest(func(int) float32 { return 1 }, "size", "100", "density", "1", "spray", "0")
+	buf, length := renderGrains(g, 0.2), int(0.1*sRate)
+	for _, x := range []struct {
+		at       int
+		expected float64
+	}{
+		{0, 0},
+		{length / 4, 0.5},
+		{length / 2, 1},
+		{3 * length / 4, 0.5},
+		{length, 0},
+		{len(buf) - 1, 0},
+	} {
+		if got := float64(buf[x.at]); math.Abs(got-x.expected) > 0.01 {
+			t.Errorf("sample %d: expected %v, got %.3f", x.at, x.expected, got)
+		}
+	}
+}
+
+func TestGrainPosition(t *testing.T) {
+	// Over a ramp, a grain plays back where in the sample it started.
+	ramp := func(i int) float32 { return float32(i) / sRate }
+	for _, position := range []float64{0, 0.25, 0.8} {
+		g := grainTest(ramp, "size", "100", "density", "1", "spray", "0", "position", strconv.FormatFloat(position, 'f', -1, 64))
+		buf, middle := renderGrains(g, 0.1), int(0.05*sRate)
+		if expected, got := position+0.05, float64(buf[middle]); math.Abs(got-expected) > 0.001 {
+			t.Errorf("position %v: expected %.3f at the peak of the window, got %.3f", position, expected, got)
+		}
+	}
+}
+
+func TestGrainSpray(t *testing.T) {
+	for _, spray := range []float64{0, 0.1, 0.5} {
+		g := grainTest(func(int) float32 { return 0 }, "position", "0.5", "spray", strconv.FormatFloat(spray, 'f', -1, 64))
+		lo, hi := math.Inf(1), math.Inf(-1)
+		for i := 0; i < 1000; i++ {
+			pos := g.grainStart() / sRate
+			lo, hi = math.Min(lo, pos), math.Max(hi, pos)
+		}
+		if lo < 0.5-spray || hi > 0.5+spray {
+			t.Errorf("spray %v: expected starts within %v of 0.5, got %.3f to %.3f", spray, spray, lo, hi)
+		}
+		if spread := hi - lo; spread < 1.8*spray {
+			t.Errorf("spray %v: expected starts spread across %v, got %.3f", spray, 2*spray, spread)
+		}
+	}
+}
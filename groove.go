@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// groove is a template of timing and velocity offsets, for steps of a note
+// value, that repeats from the start of each bar. The clock plays its pulses
+// early or late to follow the timing, and whatever plays notes on its steps
+// can ask it for the velocity.
+type groove struct {
+	unit     float64   // length of a step, in beats
+	timing   []float64 // how late each step is, in steps; early if negative
+	velocity []float64 // added to each step's velocity
+}
+
+const maxGrooveTiming = 0.45 // of a step, so pulses never run backwards
+
+// swingGroove is swing as a groove: every other step of unit, late by
+// percent of the pair, where 50% is straight and 66.7% is triplets.
+func swingGroove(percent, unit float64) *groove {
+	return &groove{
+		unit:     unit,
+		timing:   []float64{0, 2*percent/100 - 1},
+		velocity: []float64{0, 0},
+	}
+}
+
+// readGroove reads a groove template: a line "unit <note value>", then a line
+// for each step, of "<timing> [velocity]". Timing is a fraction of a step,
+// from -0.45 to 0.45, and velocity an offset from -1 to 1. Blank lines and
+// lines starting with # are ignored.
+func readGroove(r io.Reader) (*groove, error) {
+	g, s, line := &groove{}, bufio.NewScanner(r), 0
+	for s.Scan() {
+		line++
+		toks := strings.Fields(s.Text())
+		if len(toks) <= 0 || strings.HasPrefix(toks[0], "#") {
+			continue
+		}
+		if toks[0] == "unit" {
+			if len(toks) != 2 {
+				return nil, fmt.Errorf("groove: line %d: want unit <note value>", line)
+			}
+			unit, err := parseNoteValue(toks[1])
+			if err != nil {
+				return nil, fmt.Errorf("groove: line %d: %s", line, err)
+			}
+			g.unit = unit
+			continue
+		}
+		if len(toks) > 2 {
+			return nil, fmt.Errorf("groove: line %d: want <timing> [velocity]", line)
+		}
+		timing, err := strconv.ParseFloat(toks[0], 64)
+		if err != nil || math.Abs(timing) > maxGrooveTiming {
+			return nil, fmt.Errorf("groove: line %d: want timing from -%v to %v", line, maxGrooveTiming, maxGrooveTiming)
+		}
+		velocity := 0.0
+		if len(toks) == 2 {
+			if velocity, err = strconv.ParseFloat(toks[1], 64); err != nil || math.Abs(velocity) > 1 {
+				return nil, fmt.Errorf("groove: line %d: want velocity from -1 to 1", line)
+			}
+		}
+		g.timing = append(g.timing, timing)
+		g.velocity = append(g.velocity, velocity)
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	if g.unit <= 0 {
+		return nil, fmt.Errorf("groove: no unit")
+	}
+	if len(g.timing) <= 0 {
+		return nil, fmt.Errorf("groove: no steps")
+	}
+	return g, nil
+}
+
+func loadGroove(filename string) (*groove, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return readGroove(f)
+}
+
+// grooveName is the name a groove file goes by: its base name, without
+// extension.
+func grooveName(filename string) string {
+	base := filepath.Base(filename)
+	return strings.ToLower(strings.TrimSuffix(base, filepath.Ext(base)))
+}
+
+// step finds the step that pulse x of a bar of bar pulses is in, and how far
+// through it. The step after the last in the bar is the next bar's first,
+// even if the last is cut short.
+func (g *groove) step(x, bar uint64, ppqn int) (k, next int, frac float64) {
+	length := g.unit * float64(ppqn)
+	k = int(math.Floor(float64(x)/length + 1e-9))
+	start := float64(k) * length
+	next = k + 1
+	if end := start + length; end >= float64(bar)-1e-9 {
+		next, length = 0, float64(bar)-start
+	}
+	frac = math.Max(0, (float64(x)-start)/length)
+	return k % len(g.timing), next % len(g.timing), frac
+}
+
+// shift is how late pulse x of a bar of bar pulses plays, in pulses. It's
+// interpolated between the steps' timings, so pulses stay in order.
+func (g *groove) shift(x, bar uint64, ppqn int) float64 {
+	k, next, frac := g.step(x, bar, ppqn)
+	steps := g.timing[k] + frac*(g.timing[next]-g.timing[k])
+	return steps * g.unit * float64(ppqn)
+}
+
+// accent is the velocity offset of the step pulse x of a bar is in.
+func (g *groove) accent(x, bar uint64, ppqn int) float64 {
+	k, _, _ := g.step(x, bar, ppqn)
+	return g.velocity[k]
+}
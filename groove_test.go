@@ -0,0 +1,98 @@
+package main
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestReadGroove(t *testing.T) {
+	for _, c := range []struct {
+		input    string
+		steps    int // 0 for an error
+		unit     float64
+		velocity float64 // of the last step
+	}{
+		{"unit 1/16\n0\n0.1 -0.2\n", 2, 0.25, -0.2},
+		{"# mpc 16ths\nunit 16th\n\n0 0.1\n0.2\n-0.05 0.3\n", 3, 0.25, 0.3},
+		{"unit 1/8t\n0\n0.1\n0.2\n", 3, 1.0 / 3, 0},
+		{"0\n0.1\n", 0, 0, 0},           // no unit
+		{"unit 1/16\n", 0, 0, 0},        // no steps
+		{"unit 1/16\n0.5\n", 0, 0, 0},   // too late
+		{"unit 1/16\n0 1.5\n", 0, 0, 0}, // too loud
+		{"unit 1/16\n0 0 0\n", 0, 0, 0},
+	} {
+		g, err := readGroove(strings.NewReader(c.input))
+		if c.steps == 0 {
+			if err == nil {
+				t.Errorf("%q: expected an error", c.input)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %s", c.input, err)
+			continue
+		}
+		if len(g.timing) != c.steps || math.Abs(g.unit-c.unit) > 1e-9 || g.velocity[len(g.velocity)-1] != c.velocity {
+			t.Errorf("%q: expected %d steps of %v, got %d of %v", c.input, c.steps, c.unit, len(g.timing), g.unit)
+		}
+	}
+}
+
+func TestGrooveShift(t *testing.T) {
+	swing := swingGroove(200.0/3, 0.25) // triplet swing on 16ths
+	for _, c := range []struct {
+		g        *groove
+		pulse    uint64
+		bar      uint64
+		ppqn     int
+		expected float64 // pulses late
+	}{
+		{swing, 0, 96, 24, 0},
+		{swing, 3, 96, 24, 1},
+		{swing, 6, 96, 24, 2},
+		{swing, 9, 96, 24, 1},
+		{swing, 12, 96, 24, 0},
+		{swing, 24, 384, 96, 8},
+		{swingGroove(50, 0.5), 12, 96, 24, 0},
+		// Three 8ths into a bar of 5/16: the last is cut short, and
+		// heads back to the first's timing by the end of the bar.
+		{&groove{unit: 0.5, timing: []float64{0, 0.25, -0.25}, velocity: []float64{0, 0, 0}}, 24, 30, 24, -3},
+		{&groove{unit: 0.5, timing: []float64{0, 0.25, -0.25}, velocity: []float64{0, 0, 0}}, 27, 30, 24, -1.5},
+	} {
+		if got := c.g.shift(c.pulse, c.bar, c.ppqn); math.Abs(got-c.expected) > 1e-6 {
+			t.Errorf("%v pulse %d of %d: expected %.3f late, got %.3f", c.g.timing, c.pulse, c.bar, c.expected, got)
+		}
+	}
+}
+
+func TestGrooveKeepsPulsesInOrder(t *testing.T) {
+	g := &groove{unit: 0.25, timing: []float64{0.45, -0.45, 0.45}, velocity: []float64{0, 0, 0}}
+	bar := meter{7, 8}.barPulses(24)
+	prev := math.Inf(-1)
+	for n := uint64(0); n < 3*bar; n++ {
+		at := float64(n) + g.shift(n%bar, bar, 24)
+		if at <= prev {
+			t.Fatalf("pulse %d plays at %.2f, not after %.2f", n, at, prev)
+		}
+		prev = at
+	}
+}
+
+func TestGrooveAccent(t *testing.T) {
+	g := &groove{unit: 0.25, timing: []float64{0, 0}, velocity: []float64{0.2, -0.3}}
+	for _, c := range []struct {
+		pulse    uint64
+		expected float64
+	}{
+		{0, 0.2},
+		{5, 0.2},
+		{6, -0.3},
+		{12, 0.2},
+		{18, -0.3},
+	} {
+		if got := g.accent(c.pulse, 96, 24); got != c.expected {
+			t.Errorf("pulse %d: expected %v, got %v", c.pulse, c.expected, got)
+		}
+	}
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/peterbourgon/field"
+)
+
+const lfoInterval = 10 * time.Millisecond // how often an lfo updates its targets
+
+// modulatable is implemented by nodes with parameters that can be driven
+// from another node, like an lfo.
+type modulatable interface {
+	identifier
+	modulate(param string, value float64) error
+}
+
+// lfo is a low-frequency oscillator node. Connect it to modulatable nodes,
+// and pick a parameter for each with the param command. Every lfoInterval,
+// it sets those parameters to offset + depth * wave, where wave is [-1..1].
+//
+// It runs at a free rate in Hz, or synced to the clock, with a period given
+// as a note value (sync 1/4) and kept in beats.
+type lfo struct {
+	id       string
+	clock    *clock
+	params   *params
+	phase    float64 // 0..1
+	held     float64 // sample-and-hold value
+	cycle    uint64  // of the clock, when synced
+	bpm      float32
+	targets  map[string]*lfoTarget
+	routes   chan paramRequest
+	connects chan connectModRequest
+	discons  chan string
+	ticks    chan position
+	tempos   chan float32
+	quit     chan chan struct{}
+}
+
+type lfoTarget struct {
+	node  modulatable
+	param string // "" = not yet routed
+}
+
+func init() {
+	registerNodeType(nodeType{
+		name:        "lfo",
+		description: "low-frequency oscillator, to modulate other nodes' params",
+		new:         func(id string, p *platform) field.Node { return newLFO(id, p.clock) },
+	})
+}
+
+func newLFO(id string, c *clock) *lfo {
+	l := &lfo{
+		id:    id,
+		clock: c,
+		params: newParams(
+			enumFuncP("shape", "sine", lfoShapes),
+			floatP("width", 0.01, 0.99, 0.5, ""), // pulse width, for pulse
+			floatP("rate", 0.01, 100, 1, "Hz"),
+			floatP("sync", 0, 64, 0, "beats"), // 0 = free running at rate
+			floatP("depth", -10000, 10000, 1, ""),
+			floatP("offset", -10000, 10000, 0, ""),
+		),
+		phase:    0.0,
+		held:     0.0,
+		bpm:      0.0,
+		targets:  map[string]*lfoTarget{},
+		routes:   make(chan paramRequest),
+		connects: make(chan connectModRequest),
+		discons:  make(chan string),
+		ticks:    make(chan position),
+		tempos:   make(chan float32),
+		quit:     make(chan chan struct{}),
+	}
+	go l.loop()
+	c.subscribe(l)
+	return l
+}
+
+func (l *lfo) stop() {
+	l.clock.unsubscribe(l) // first, so the clock isn't stuck ticking us
+	q := make(chan struct{})
+	l.quit <- q
+	<-q
+}
+
+func (l *lfo) loop() {
+	log.Printf("%s: started", l.ID())
+	defer log.Printf("%s: done", l.ID())
+
+	t := time.NewTicker(lfoInterval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-t.C:
+			l.advance(lfoInterval.Seconds())
+			l.update()
+
+		case pos := <-l.ticks:
+			l.align(pos)
+
+		case bpm := <-l.tempos:
+			l.bpm = bpm
+
+		case r := <-l.routes:
+			t, ok := l.targets[r.id]
+			if !ok {
+				r.e <- fmt.Errorf("not connected to %s", r.id)
+				continue
+			}
+			t.param = r.param
+			r.e <- nil
+
+		case r := <-l.connects:
+			if _, ok := l.targets[r.m.ID()]; ok {
+				r.e <- fmt.Errorf("%s already connected to %s", l.ID(), r.m.ID())
+				continue
+			}
+			l.targets[r.m.ID()] = &lfoTarget{node: r.m}
+			r.e <- nil
+			log.Printf("%s → %s", l.ID(), r.m.ID())
+
+		case id := <-l.discons:
+			delete(l.targets, id)
+			log.Printf("%s ✕ %s", l.ID(), id)
+
+		case q := <-l.quit:
+			close(q)
+			return
+		}
+	}
+}
+
+// advance moves the phase on by the given number of seconds.
+func (l *lfo) advance(seconds float64) {
+	hz := l.params.float("rate")
+	if beats := l.params.float("sync"); beats > 0 {
+		hz = float64(l.bpm) / 60 / beats
+	}
+	l.phase += hz * seconds
+	if l.phase >= 1 {
+		l.phase -= math.Floor(l.phase)
+		if l.params.float("sync") <= 0 {
+			l.held = 2*rand.Float64() - 1 // synced, align does this
+		}
+	}
+}
+
+// align re-aligns a synced lfo's phase to the clock, on each pulse. A synced
+// lfo takes a new value to hold when the clock starts a new cycle, rather
+// than when its phase wraps, since re-aligning moves the phase both ways.
+func (l *lfo) align(pos position) {
+	beats := l.params.float("sync")
+	if beats <= 0 {
+		return
+	}
+	cycles := pos.beats() / beats
+	if cycle := uint64(cycles); cycle != l.cycle {
+		l.held, l.cycle = 2*rand.Float64()-1, cycle
+	}
+	l.phase = cycles - math.Floor(cycles)
+}
+
+func (l *lfo) update() {
+	value := l.params.float("offset") + l.params.float("depth")*l.wave()
+	for id, t := range l.targets {
+		if t.param == "" {
+			continue
+		}
+		if err := t.node.modulate(t.param, value); err != nil {
+			log.Printf("%s: %s %s: %s, unrouting", l.ID(), id, t.param, err)
+			t.param = ""
+		}
+	}
+}
+
+// lfoShapes are the waveforms, and sample and hold.
+func lfoShapes() []string {
+	return append([]string{"sh"}, waveformNames()...)
+}
+
+// wave is the lfo's output at its current phase, in [-1..1].
+func (l *lfo) wave() float64 {
+	shape := l.params.enum("shape")
+	if shape == "sh" {
+		return l.held
+	}
+	return float64(waveforms.get(shape).at(float32(l.phase), float32(l.params.float("width"))))
+}
+
+func (l *lfo) parse(input string) {
+	input = strings.TrimSpace(strings.ToLower(input))
+	toks := strings.Split(input, " ")
+	if len(toks) <= 0 {
+		log.Printf("%s: parse empty", l.ID())
+		return
+	}
+
+	switch toks[0] {
+	case "wave":
+		setParam(l, append([]string{"shape"}, toks[1:]...))
+
+	case "rate", "hz":
+		if setParam(l, append([]string{"rate"}, toks[1:]...)) {
+			l.params.set("sync", "0")
+		}
+
+	case "sync":
+		if len(toks) < 2 {
+			log.Printf("%s: %s: not enough", l.ID(), input)
+			return
+		}
+		beats, err := parseNoteValue(toks[1])
+		if err != nil {
+			log.Printf("%s: %s: %s", l.ID(), input, err)
+			return
+		}
+		setParam(l, []string{"sync", strconv.FormatFloat(beats, 'f', -1, 64)})
+
+	case "param", "route":
+		if len(toks) < 3 {
+			log.Printf("%s: %s: need target and param", l.ID(), input)
+			return
+		}
+		req := paramRequest{toks[1], toks[2], make(chan error)}
+		l.routes <- req
+		if err := <-req.e; err != nil {
+			log.Printf("%s: %s: %s", l.ID(), input, err)
+			return
+		}
+		log.Printf("%s: %s: OK", l.ID(), input)
+
+	default:
+		if !l.params.has(toks[0]) {
+			log.Printf("%s: %s: aroo", l.ID(), input)
+			return
+		}
+		setParam(l, toks)
+	}
+}
+
+func (l *lfo) ID() string          { return l.id }
+func (l *lfo) parameters() *params { return l.params }
+
+func (l *lfo) tick(p position)   { l.ticks <- p }
+func (l *lfo) tempo(bpm float32) { l.tempos <- bpm }
+
+// controls implements the controller interface.
+func (l *lfo) controls(n field.Node) bool {
+	_, ok := n.(modulatable)
+	return ok
+}
+
+func (l *lfo) Connect(n field.Node) error {
+	m, ok := n.(modulatable)
+	if !ok {
+		return fmt.Errorf("%s not modulatable", n.ID())
+	}
+	req := connectModRequest{m, make(chan error)}
+	l.connects <- req
+	return <-req.e
+}
+
+func (l *lfo) Disconnect(n field.Node) {
+	l.discons <- n.ID()
+}
+
+func (l *lfo) Connection(n field.Node) error {
+	log.Printf("%s: Connection(%s): no", l.ID(), n.ID())
+	return errNo
+}
+
+func (l *lfo) Disconnection(n field.Node) {}
+
+type connectModRequest struct {
+	m modulatable
+	e chan error
+}
+
+// paramRequest routes a modulator to a parameter of one of its targets.
+type paramRequest struct {
+	id    string
+	param string
+	e     chan error
+}
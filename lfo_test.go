@@ -0,0 +1,58 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLFOSampleAndHold(t *testing.T) {
+	c := newClock(120)
+	defer c.stop()
+
+	for _, x := range []struct {
+		commands []string
+		ppqn     int
+		expected int // new values held in 3s
+	}{
+		{[]string{"rate 2"}, 24, 6},
+		{[]string{"sync 1/4"}, 24, 6},
+		{[]string{"sync 1/4"}, 96, 6},
+		{[]string{"sync 1/4"}, 480, 6},
+		{[]string{"sync 1/2"}, 96, 3},
+		{[]string{"sync 1/8."}, 24, 8},
+	} {
+		l := newLFO("lfo", c)
+		l.stop() // so we can drive it
+		l.bpm = 120
+		l.parse("shape sh")
+		for _, cmd := range x.commands {
+			l.parse(cmd)
+		}
+
+		// Play 3s of updates and pulses, in time order, as the loop would.
+		var (
+			pulse   = time.Duration(float64(bpm2duration(120)) / float64(x.ppqn))
+			next    = time.Duration(0) // the next pulse
+			n       = uint64(0)
+			changes = 0
+		)
+		for now := lfoInterval; now <= 3*time.Second; now += lfoInterval {
+			for ; next <= now; next += pulse {
+				held := l.held
+				l.align(position{pulse: n, ppqn: x.ppqn})
+				if l.held != held {
+					changes++
+				}
+				n++
+			}
+			held := l.held
+			l.advance(lfoInterval.Seconds())
+			if l.held != held {
+				changes++
+			}
+		}
+		if changes != x.expected {
+			t.Errorf("%v at %d ppqn: expected %d new values, got %d", x.commands, x.ppqn, x.expected, changes)
+		}
+	}
+}
@@ -60,15 +60,21 @@ func main() {
 	defer time.Sleep(2 * cycle)
 	quit := make(chan struct{})
 	defer close(quit)
-	in := make(chan string)
+	in := make(chan message)
 
 	go rd(in, conn, quit)
-	go wr(p, in, session, quit)
+	go wr(p, in, conn, session, quit)
 
 	<-interrupt()
 }
 
-func rd(out chan string, conn *net.UDPConn, quit chan struct{}) {
+// message is one datagram from a client.
+type message struct {
+	text string
+	from net.Addr
+}
+
+func rd(out chan message, conn *net.UDPConn, quit chan struct{}) {
 	log.Printf("rd: %s", conn.LocalAddr())
 	defer log.Printf("rd: done")
 	const maxSize = 4096
@@ -88,18 +94,27 @@ func rd(out chan string, conn *net.UDPConn, quit chan struct{}) {
 				log.Printf("%s: too big", remoteAddr)
 				continue
 			}
-			out <- string(b[:n])
+			out <- message{string(b[:n]), remoteAddr}
 		}
 	}
 }
 
-func wr(p *platform, in chan string, session chan string, quit chan struct{}) {
+// wr hands commands to the platform. Queries are answered back to whoever
+// asked.
+func wr(p *platform, in chan message, conn *net.UDPConn, session chan string, quit chan struct{}) {
 	defer log.Printf("wr: done")
 	for {
 		select {
-		case s := <-in:
-			s = strings.TrimSpace(s)
+		case m := <-in:
+			s := strings.TrimSpace(m.text)
 			session <- fmt.Sprintf("%d %s", time.Now().UTC().UnixNano(), s)
+			if reply, ok := p.query(s); ok {
+				log.Printf("%s: %s", s, reply)
+				if _, err := conn.WriteTo([]byte(reply+"\n"), m.from); err != nil {
+					log.Printf("%s: %s", m.from, err)
+				}
+				continue
+			}
 			p.parse(s)
 
 		case <-quit:
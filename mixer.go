@@ -2,6 +2,7 @@ package main
 
 import (
 	"log"
+	"strings"
 
 	"code.google.com/p/portaudio-go/portaudio"
 	"github.com/peterbourgon/field"
@@ -23,13 +24,21 @@ type audioReceiver interface {
 	receive(audioOut <-chan []float32)
 }
 
+// sourceReceiver is an audioReceiver that wants to know where its audio comes
+// from, to treat some of it differently, like a compressor's sidechain. It's
+// handed connections through receiveFrom instead of receive.
+type sourceReceiver interface {
+	audioReceiver
+	receiveFrom(src string, audioOut <-chan []float32)
+}
+
 type stopper interface {
 	stop()
 }
 
 type mixer struct {
 	stream   *portaudio.Stream
-	gain     float32
+	params   *params
 	incoming chan (<-chan []float32) // connections from upstream
 	audio    chan chan []float32
 	quit     chan chan struct{}
@@ -38,7 +47,7 @@ type mixer struct {
 func newMixer() (*mixer, error) {
 	m := &mixer{
 		stream:   nil,
-		gain:     0.1, // TODO make mutable
+		params:   newParams(floatP("gain", 0, 1, 0.1, "")),
 		incoming: make(chan (<-chan []float32)),
 		audio:    make(chan chan []float32),
 		quit:     make(chan chan struct{}),
@@ -73,7 +82,7 @@ func (m *mixer) loop() {
 
 		case c := <-m.audio:
 			var buf []float32
-			incoming, buf = mux(incoming, m.gain)
+			incoming, buf = mux(m.ID(), incoming, float32(m.params.float("gain")))
 			c <- buf
 
 		case q := <-m.quit:
@@ -116,9 +125,32 @@ func (m *mixer) receive(audioOut <-chan []float32) {
 	m.incoming <- audioOut
 }
 
-var zeroBuf = make([]float32, 0.0)
+func (m *mixer) parse(input string) {
+	input = strings.TrimSpace(strings.ToLower(input))
+	toks := strings.Split(input, " ")
+	if len(toks) <= 0 {
+		log.Printf("mixer: parse empty")
+		return
+	}
+	if !m.params.has(toks[0]) {
+		log.Printf("mixer: %s: aroo", input)
+		return
+	}
+	setParam(m, toks)
+}
+
+func (m *mixer) parameters() *params { return m.params }
+
+// modulate implements the modulatable interface.
+func (m *mixer) modulate(param string, value float64) error {
+	return m.params.modulate(param, value)
+}
+
+var zeroBuf = make([]float32, bufSz)
 
-func mux(incoming []<-chan []float32, gain float32) ([]<-chan []float32, []float32) {
+// mux sums a buffer from each incoming channel, for the node with the given
+// ID, and culls the ones that have closed.
+func mux(id string, incoming []<-chan []float32, gain float32) ([]<-chan []float32, []float32) {
 	survivors := make([]<-chan []float32, 0, len(incoming))
 	out := make([]float32, bufSz)
 	for _, c := range incoming {
@@ -131,11 +163,11 @@ func mux(incoming []<-chan []float32, gain float32) ([]<-chan []float32, []float
 			timeout = true
 		}
 		if !ok {
-			log.Printf("mixer: mux: ch %x closed, culling", c)
+			log.Printf("%s: mux: ch %x closed, culling", id, c)
 			continue
 		}
 		if timeout {
-			log.Printf("mixer: mux: ch %x timeout, skipping", c)
+			log.Printf("%s: mux: ch %x timeout, skipping", id, c)
 			buf = zeroBuf
 		}
 		if len(buf) != len(out) {
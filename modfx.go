@@ -0,0 +1,215 @@
+package main
+
+import (
+	"log"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/peterbourgon/field"
+)
+
+// Chorus, flanger and phaser are one effect, modFX, with different kinds of
+// sweep. Chorus and flanger sweep a delay line; phaser sweeps the corner of a
+// chain of allpass filters. All of them sweep with a sine LFO, free running
+// at rate, or synced to the clock as a note value.
+const (
+	chorusDelay  = 0.015  // seconds, at the centre of the sweep
+	chorusSweep  = 0.008  // seconds either side, at depth 1
+	chorusVoices = 3      // at phases spread over the LFO cycle
+	flangerDelay = 0.0002 // seconds, at the bottom of the sweep
+	flangerSweep = 0.005  // seconds above that, at depth 1
+	phaserLowHz  = 200.0  // bottom of the sweep
+	phaserHighHz = 4000.0 // top of the sweep, at depth 1
+	maxModDelay  = chorusDelay + chorusSweep + 0.001
+)
+
+type modFX struct {
+	*effect
+	kind   string // chorus, flanger or phaser
+	clock  *clock
+	phase  float64 // of the LFO, 0..1
+	line   *modLine
+	stages []allpass1
+	last   float32 // wet output, for feedback
+}
+
+func init() {
+	registerNodeType(nodeType{
+		name:        "chorus",
+		description: "chorus effect",
+		new:         func(id string, p *platform) field.Node { return newChorus(id, p.clock) },
+	})
+	registerNodeType(nodeType{
+		name:        "flanger",
+		description: "flanger effect",
+		new:         func(id string, p *platform) field.Node { return newFlanger(id, p.clock) },
+	})
+	registerNodeType(nodeType{
+		name:        "phaser",
+		description: "phaser effect",
+		new:         func(id string, p *platform) field.Node { return newPhaser(id, p.clock) },
+	})
+}
+
+func newChorus(id string, c *clock) *modFX  { return newModFX(id, "chorus", c, 0.8, 0.5, 0) }
+func newFlanger(id string, c *clock) *modFX { return newModFX(id, "flanger", c, 0.2, 0.7, 0.5) }
+func newPhaser(id string, c *clock) *modFX  { return newModFX(id, "phaser", c, 0.3, 0.8, 0.3) }
+
+func newModFX(id, kind string, c *clock, rate, depth, feedback float64) *modFX {
+	m := &modFX{
+		kind:  kind,
+		clock: c,
+		line:  newModLine(int(math.Ceil(maxModDelay*sRate)) + 2),
+	}
+	ps := []*param{
+		floatP("rate", 0.01, 20, rate, "Hz"),
+		floatP("sync", 0, 64, 0, "beats"), // 0 = free running at rate
+		floatP("depth", 0, 1, depth, ""),
+		floatP("feedback", -0.95, 0.95, feedback, ""),
+		floatP("mix", 0, 1, 0.5, ""),
+		readOnly(floatP("bpm", 1, 999, 120, "")), // from the clock
+	}
+	if kind == "phaser" {
+		ps = append(ps, intP("stages", 2, 12, 4))
+	}
+	m.effect = newEffect(id, newParams(ps...), m)
+	c.subscribe(m)
+	return m
+}
+
+func (m *modFX) stop() {
+	m.clock.unsubscribe(m) // first, so the clock isn't stuck telling us
+	m.effect.stop()
+}
+
+func (m *modFX) process(in []float32) []float32 {
+	hz := m.params.float("rate")
+	if beats := m.params.float("sync"); beats > 0 {
+		hz = m.params.float("bpm") / 60 / beats
+	}
+	var (
+		step     = hz / sRate
+		depth    = m.params.float("depth")
+		feedback = float32(m.params.float("feedback"))
+		wet      = make([]float32, len(in))
+	)
+
+	for i, x := range in {
+		switch m.kind {
+		case "chorus":
+			var sum float32
+			for v := 0; v < chorusVoices; v++ {
+				lfo := math.Sin(2 * math.Pi * (m.phase + float64(v)/chorusVoices))
+				sum += m.line.read((chorusDelay + depth*chorusSweep*lfo) * sRate)
+			}
+			m.last = sum / chorusVoices
+			m.line.write(x + feedback*m.last)
+
+		case "flanger":
+			lfo := 0.5 + 0.5*math.Sin(2*math.Pi*m.phase)
+			m.last = m.line.read((flangerDelay + depth*flangerSweep*lfo) * sRate)
+			m.line.write(x + feedback*m.last)
+
+		case "phaser":
+			lfo := 0.5 + 0.5*math.Sin(2*math.Pi*m.phase)
+			m.last = m.phase1(x+feedback*m.last, phaserLowHz*math.Pow(phaserHighHz/phaserLowHz, depth*lfo))
+		}
+		wet[i] = m.last
+
+		if m.phase += step; m.phase >= 1 {
+			m.phase -= 1
+		}
+	}
+	return mix(in, wet, float32(m.params.float("mix")))
+}
+
+// phase1 runs one sample through the allpass chain, with corners at hz.
+func (m *modFX) phase1(x float32, hz float64) float32 {
+	n := m.params.int("stages")
+	for len(m.stages) < n {
+		m.stages = append(m.stages, allpass1{})
+	}
+	m.stages = m.stages[:n]
+
+	a := allpassCoeff(hz)
+	for j := range m.stages {
+		x = m.stages[j].process(x, a)
+	}
+	return x
+}
+
+func (m *modFX) parse(input string) {
+	toks := strings.Split(strings.TrimSpace(strings.ToLower(input)), " ")
+	switch toks[0] {
+	case "rate", "hz":
+		if setParam(m, append([]string{"rate"}, toks[1:]...)) {
+			m.params.set("sync", "0")
+		}
+
+	case "sync":
+		if len(toks) < 2 {
+			log.Printf("%s: %s: not enough", m.ID(), input)
+			return
+		}
+		beats, err := parseNoteValue(toks[1])
+		if err != nil {
+			log.Printf("%s: %s: %s", m.ID(), input, err)
+			return
+		}
+		setParam(m, []string{"sync", strconv.FormatFloat(beats, 'f', -1, 64)})
+
+	default:
+		m.effect.parse(input)
+	}
+}
+
+func (m *modFX) tick(position)     {}
+func (m *modFX) tempo(bpm float32) { m.params.report("bpm", float64(bpm)) }
+
+// modLine is a delay line that can be read at a fractional, moving delay.
+type modLine struct {
+	buf []float32
+	w   int // next write position
+}
+
+func newModLine(n int) *modLine {
+	return &modLine{buf: make([]float32, n)}
+}
+
+// read returns the sample written delay samples before the next write, where
+// delay is at least 1, interpolating between samples.
+func (l *modLine) read(delay float64) float32 {
+	n := len(l.buf)
+	delay = math.Max(1, math.Min(delay, float64(n-1)))
+	r := float64(l.w) - delay
+	if r < 0 {
+		r += float64(n)
+	}
+	i := int(r)
+	frac := float32(r - float64(i))
+	return (1-frac)*l.buf[i%n] + frac*l.buf[(i+1)%n]
+}
+
+func (l *modLine) write(x float32) {
+	l.buf[l.w] = x
+	l.w = (l.w + 1) % len(l.buf)
+}
+
+// allpass1 is a first-order allpass filter: flat in level, with a phase
+// shift that passes 90° at its corner.
+type allpass1 struct {
+	s float32
+}
+
+func (f *allpass1) process(x, a float32) float32 {
+	y := a*x + f.s
+	f.s = x - a*y
+	return y
+}
+
+// allpassCoeff is the coefficient for an allpass1 with its corner at hz.
+func allpassCoeff(hz float64) float32 {
+	t := math.Tan(math.Pi * math.Min(hz, 0.45*sRate) / sRate)
+	return float32((t - 1) / (t + 1))
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestModLine(t *testing.T) {
+	l := newModLine(8)
+	for _, v := range []float32{1, 2, 3, 4, 5} {
+		l.write(v)
+	}
+	for _, c := range []struct {
+		delay    float64
+		expected float32
+	}{
+		{1, 5},
+		{2, 4},
+		{1.5, 4.5},
+		{4.25, 1.75},
+		{0.5, 5}, // at least 1
+		{5, 1},
+		{6, 0}, // before anything was written
+	} {
+		if got := l.read(c.delay); !cmpFloat32(got, c.expected, 0.0001) {
+			t.Errorf("read(%v): expected %v, got %v", c.delay, c.expected, got)
+		}
+	}
+}
+
+func TestAllpass1(t *testing.T) {
+	// Flat in level everywhere, whatever the corner.
+	for _, corner := range []float64{100, 1000, 10000} {
+		for _, hz := range []float64{50, 500, 5000, 15000} {
+			f := &allpass1{}
+			a := allpassCoeff(corner)
+			p := processorFunc(func(in []float32) []float32 {
+				out := make([]float32, len(in))
+				for i, x := range in {
+					out[i] = f.process(x, a)
+				}
+				return out
+			})
+			if got := gain(p, hz); math.Abs(got-1) > 0.02 {
+				t.Errorf("corner %v Hz, at %v Hz: expected gain 1, got %.3f", corner, hz, got)
+			}
+		}
+	}
+}
+
+func TestModFXStill(t *testing.T) {
+	// At depth 0, with no feedback, each is a fixed delay or allpass chain.
+	c := newClock(120)
+	defer c.stop()
+	for _, m := range []*modFX{
+		newChorus("chorus", c),
+		newFlanger("flanger", c),
+		newPhaser("phaser", c),
+	} {
+		m.parse("depth 0")
+		m.parse("feedback 0")
+		m.parse("mix 1")
+
+		switch m.kind {
+		case "chorus", "flanger":
+			delay := chorusDelay * sRate
+			if m.kind == "flanger" {
+				delay = flangerDelay * sRate
+			}
+			in := make([]float32, bufSz)
+			in[0] = 1
+			out := m.process(in)
+			i := int(delay)
+			frac := float32(delay - float64(i))
+			if !cmpFloat32(out[i], 1-frac, 0.001) || !cmpFloat32(out[i+1], frac, 0.001) {
+				t.Errorf("%s: expected the impulse at %.2f, got %v, %v", m.kind, delay, out[i], out[i+1])
+			}
+		case "phaser":
+			if got := gain(m, 1000); math.Abs(got-1) > 0.02 {
+				t.Errorf("phaser: expected gain 1, got %.3f", got)
+			}
+		}
+		m.stop()
+	}
+}
+
+type processorFunc func([]float32) []float32
+
+func (f processorFunc) process(in []float32) []float32 { return f(in) }
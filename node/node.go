@@ -0,0 +1,99 @@
+// Package node lets packages outside gmd add node types to it. A package
+// registers its types from an init function, and a gmd build imports it for
+// that, like a database/sql driver:
+//
+//	import _ "example.com/wobble"
+//
+// gmd runs each node of a registered type like its own effects: it sums the
+// audio connected to the node, hands it to the node's Processor a buffer at
+// a time, and sends what comes back downstream. A node with nothing
+// connected gets silence, so a Processor can be a generator too. Its params
+// can be set and modulated like any node's.
+package node
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// SampleRate and BufferSize are gmd's: Processors get and return buffers of
+// BufferSize samples, at SampleRate.
+const (
+	SampleRate = 44100
+	BufferSize = 1024
+)
+
+// Type is a kind of node, added in gmd with
+//
+//	add <name> <id> [param=value ...]
+type Type struct {
+	Name        string
+	Description string
+	Params      []Param
+	New         func(id string) Processor
+}
+
+// Param is a number a node can be set or modulated on.
+type Param struct {
+	Name     string
+	Min, Max float64
+	Default  float64
+	Unit     string // for display, like "ms"; may be empty
+}
+
+// Params are the current values of a node's params.
+type Params interface {
+	Float(name string) float64
+}
+
+// Processor is what a node does to its audio. Process is given each buffer
+// of input, which it may change, and returns a buffer of output.
+type Processor interface {
+	Process(in []float32, p Params) []float32
+}
+
+// Player is a Processor that can be played, by an arp or anything else that
+// sends keys. Keys are MIDI note numbers, which may be fractional; KeyUp(0)
+// means let go of every key. Its methods are never called at the same time
+// as Process.
+type Player interface {
+	Processor
+	KeyDown(key float64, velocity float32)
+	KeyUp(key float64)
+}
+
+var (
+	mtx   sync.Mutex
+	types = map[string]Type{}
+)
+
+// Register adds a node type. It panics if the type has no name or New, or
+// its name is taken, since those are mistakes in the build.
+func Register(t Type) {
+	mtx.Lock()
+	defer mtx.Unlock()
+	if t.Name == "" || t.New == nil {
+		panic("node: type needs a name and New")
+	}
+	if _, ok := types[t.Name]; ok {
+		panic(fmt.Sprintf("node: type %s registered twice", t.Name))
+	}
+	types[t.Name] = t
+}
+
+// Types are the registered node types, by name.
+func Types() []Type {
+	mtx.Lock()
+	defer mtx.Unlock()
+	names := []string{}
+	for name := range types {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	list := make([]Type, 0, len(names))
+	for _, name := range names {
+		list = append(list, types[name])
+	}
+	return list
+}
@@ -0,0 +1,31 @@
+package node
+
+import "testing"
+
+type silence struct{}
+
+func (silence) Process(in []float32, p Params) []float32 { return make([]float32, BufferSize) }
+
+func TestRegister(t *testing.T) {
+	newSilence := func(string) Processor { return silence{} }
+	Register(Type{Name: "b", New: newSilence})
+	Register(Type{Name: "a", New: newSilence})
+	if got := Types(); len(got) != 2 || got[0].Name != "a" || got[1].Name != "b" {
+		t.Errorf("expected types a and b, got %v", got)
+	}
+
+	for _, typ := range []Type{
+		{Name: "a", New: newSilence}, // taken
+		{Name: "", New: newSilence},
+		{Name: "c"},
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%+v: expected a panic", typ)
+				}
+			}()
+			Register(typ)
+		}()
+	}
+}
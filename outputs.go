@@ -0,0 +1,225 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+// outputDeadline is how long a buffer waits for outputs to take it. That's a
+// buffer's worth of audio, so a receiver keeping up has read the last one by
+// then.
+const outputDeadline = bufSz * time.Second / sRate
+
+// outputs fans a node's audio out to any number of downstream receivers,
+// each at its own send level. A node loads a buffer, and then sends it to
+// each output from its select loop, skipping the ones still holding the last
+// buffer when the deadline passes.
+//
+//	for {
+//	    if o.idle() {
+//	        o.load(render())
+//	    }
+//	    c, buf := o.next()
+//	    select {
+//	    case c <- buf:
+//	        o.sent()
+//	    case <-o.expired():
+//	        o.skip()
+//	    ...
+//
+// So a receiver that's slow or has stopped reading loses buffers, like an
+// input mux skips a late input, and doesn't hold up the others.
+//
+// Receivers must not modify the buffers they get.
+type outputs struct {
+	list    []*output
+	pending []*output // still to get buf
+	buf     []float32
+	cur     int         // the pending output next returned
+	timer   *time.Timer // for the deadline
+}
+
+type output struct {
+	receiver audioReceiver
+	c        chan []float32
+	level    float32
+	behind   bool // skipped, and not caught up since
+}
+
+// connect adds an output from the node with the given ID to r, at unity
+// level, and hands r its end.
+func (o *outputs) connect(from string, r audioReceiver) error {
+	if o.find(r.ID()) != nil {
+		return fmt.Errorf("already connected to %s", r.ID())
+	}
+	out := &output{
+		receiver: r,
+		c:        make(chan []float32, 1),
+		level:    1.0,
+	}
+	o.list = append(o.list, out)
+	if sr, ok := r.(sourceReceiver); ok {
+		sr.receiveFrom(from, out.c)
+	} else {
+		r.receive(out.c)
+	}
+	return nil
+}
+
+// disconnect closes the output to the receiver with the given ID, leaving
+// any others alone.
+func (o *outputs) disconnect(id string) error {
+	out := o.find(id)
+	if out == nil {
+		return fmt.Errorf("not connected to %s", id)
+	}
+	o.list = removeOutput(o.list, out)
+	o.pending = removeOutput(o.pending, out)
+	close(out.c)
+	return nil
+}
+
+// closeAll closes every output, for when a node stops.
+func (o *outputs) closeAll() {
+	for _, out := range o.list {
+		close(out.c)
+	}
+	o.list, o.pending = nil, nil
+}
+
+func (o *outputs) setLevel(id string, level float32) error {
+	out := o.find(id)
+	if out == nil {
+		return fmt.Errorf("not connected to %s", id)
+	}
+	out.level = level
+	return nil
+}
+
+// idle is true when there are outputs, and they've all got the last buffer.
+func (o *outputs) idle() bool {
+	return len(o.list) > 0 && len(o.pending) <= 0
+}
+
+// load makes buf the buffer to send to every output, and starts the
+// deadline for it.
+func (o *outputs) load(buf []float32) {
+	o.buf = buf
+	o.pending = append([]*output{}, o.list...)
+	if o.timer == nil {
+		o.timer = time.NewTimer(outputDeadline)
+		return
+	}
+	if !o.timer.Stop() {
+		select {
+		case <-o.timer.C:
+		default:
+		}
+	}
+	o.timer.Reset(outputDeadline)
+}
+
+// next returns the channel the current buffer should go to next, and the
+// buffer at that output's level. Outputs with room in their channels go
+// first, then ones that have been keeping up, so they aren't kept waiting on
+// one that isn't. With nothing to send, the channel is nil, which blocks
+// forever in a select.
+func (o *outputs) next() (chan<- []float32, []float32) {
+	if len(o.pending) <= 0 {
+		return nil, nil
+	}
+	o.cur = o.first(func(out *output) bool { return len(out.c) < cap(out.c) })
+	if o.cur < 0 {
+		o.cur = o.first(func(out *output) bool { return !out.behind })
+	}
+	if o.cur < 0 {
+		o.cur = 0
+	}
+	out := o.pending[o.cur]
+	if out.level == 1.0 {
+		return out.c, o.buf
+	}
+	buf := make([]float32, len(o.buf))
+	for i, v := range o.buf {
+		buf[i] = out.level * v
+	}
+	return out.c, buf
+}
+
+// sent marks the output returned by next as done.
+func (o *outputs) sent() {
+	out := o.pending[o.cur]
+	if out.behind {
+		log.Printf("%s: caught up", out.receiver.ID())
+		out.behind = false
+	}
+	o.pending = append(o.pending[:o.cur], o.pending[o.cur+1:]...)
+}
+
+// expired fires when the current buffer's deadline passes with outputs still
+// to take it. With nothing to send, it's nil, which blocks forever in a
+// select.
+func (o *outputs) expired() <-chan time.Time {
+	if len(o.pending) <= 0 {
+		return nil
+	}
+	return o.timer.C
+}
+
+// skip gives up on sending the current buffer to the outputs that still
+// haven't taken the one before. Any with room by now get it next.
+func (o *outputs) skip() {
+	pending := o.pending[:0]
+	for _, out := range o.pending {
+		if len(out.c) < cap(out.c) {
+			pending = append(pending, out)
+			continue
+		}
+		if !out.behind {
+			log.Printf("%s: not keeping up, skipping", out.receiver.ID())
+			out.behind = true
+		}
+	}
+	o.pending = pending
+}
+
+// first is the index of the first pending output that ok likes, or -1.
+func (o *outputs) first(ok func(*output) bool) int {
+	for i, out := range o.pending {
+		if ok(out) {
+			return i
+		}
+	}
+	return -1
+}
+
+func (o *outputs) find(id string) *output {
+	for _, out := range o.list {
+		if out.receiver.ID() == id {
+			return out
+		}
+	}
+	return nil
+}
+
+func (o *outputs) String() string {
+	s := ""
+	for i, out := range o.list {
+		if i > 0 {
+			s += ", "
+		}
+		s += fmt.Sprintf("%s (%.2f)", out.receiver.ID(), out.level)
+	}
+	return "[" + s + "]"
+}
+
+func removeOutput(list []*output, out *output) []*output {
+	survivors := make([]*output, 0, len(list))
+	for _, o := range list {
+		if o != out {
+			survivors = append(survivors, o)
+		}
+	}
+	return survivors
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+type chanReceiver struct {
+	id string
+	c  <-chan []float32
+}
+
+func (r *chanReceiver) ID() string                        { return r.id }
+func (r *chanReceiver) receive(audioOut <-chan []float32) { r.c = audioOut }
+
+func TestOutputsFanOut(t *testing.T) {
+	var o outputs
+	dry, wet := &chanReceiver{id: "dry"}, &chanReceiver{id: "wet"}
+	if err := o.connect("gen", dry); err != nil {
+		t.Fatal(err)
+	}
+	if err := o.connect("gen", wet); err != nil {
+		t.Fatal(err)
+	}
+	if err := o.connect("gen", wet); err == nil {
+		t.Errorf("double connect: expected error")
+	}
+	if err := o.setLevel("wet", 0.5); err != nil {
+		t.Fatal(err)
+	}
+
+	send := func(v float32) {
+		if !o.idle() {
+			t.Fatalf("expected idle before load")
+		}
+		o.load([]float32{v})
+		for c, buf := o.next(); c != nil; c, buf = o.next() {
+			c <- buf
+			o.sent()
+		}
+	}
+
+	send(1.0)
+	if got := (<-dry.c)[0]; got != 1.0 {
+		t.Errorf("dry: expected 1.0, got %.2f", got)
+	}
+	if got := (<-wet.c)[0]; got != 0.5 {
+		t.Errorf("wet: expected 0.5, got %.2f", got)
+	}
+
+	if err := o.disconnect("wet"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := <-wet.c; ok {
+		t.Errorf("wet: expected closed")
+	}
+	send(2.0)
+	if got := (<-dry.c)[0]; got != 2.0 {
+		t.Errorf("dry after disconnect: expected 2.0, got %.2f", got)
+	}
+}
+
+func TestOutputsStalledReceiver(t *testing.T) {
+	var o outputs
+	stalled, dry := &chanReceiver{id: "stalled"}, &chanReceiver{id: "dry"}
+	for _, r := range []*chanReceiver{stalled, dry} { // stalled first, to be in the way
+		if err := o.connect("gen", r); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	const n = 5
+	got := make(chan []float32, n)
+	go func() {
+		for i := 0; i < n; i++ {
+			got <- <-dry.c
+		}
+	}()
+
+	for sent := 0; sent < n; {
+		if o.idle() {
+			o.load([]float32{float32(sent)})
+			sent++
+		}
+		c, buf := o.next()
+		select {
+		case c <- buf:
+			o.sent()
+		case <-o.expired():
+			o.skip()
+		}
+	}
+	for i := 0; i < n; i++ {
+		select {
+		case buf := <-got:
+			if buf[0] != float32(i) {
+				t.Errorf("dry: expected buffer %d, got %v", i, buf[0])
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("dry: expected buffer %d, got nothing", i)
+		}
+	}
+	if buf := <-stalled.c; buf[0] != 0 {
+		t.Errorf("stalled: expected the first buffer, that its channel holds, got %v", buf[0])
+	}
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// parameterized is implemented by nodes that declare their parameters, so
+// the platform can set, get and list them generically.
+type parameterized interface {
+	identifier
+	parameters() *params
+}
+
+type paramKind int
+
+const (
+	floatParam paramKind = iota
+	intParam
+	enumParam
+	boolParam
+)
+
+// param describes one of a node's parameters. Every kind of value is held as
+// a float64: enums as the index of their option, and bools as 0 or 1.
+type param struct {
+	name     string
+	kind     paramKind
+	min, max float64         // float and int
+	unit     string          // float and int
+	options  []string        // enum
+	choices  func() []string // enum, instead of options, if they can grow
+	readOnly bool            // the node reports it, but it can't be set
+	value    float64
+}
+
+func floatP(name string, min, max, value float64, unit string) *param {
+	return &param{name: name, kind: floatParam, min: min, max: max, unit: unit, value: value}
+}
+
+func intP(name string, min, max, value int) *param {
+	return &param{name: name, kind: intParam, min: float64(min), max: float64(max), value: float64(value)}
+}
+
+func enumP(name string, value string, options ...string) *param {
+	p := &param{name: name, kind: enumParam, options: options}
+	p.value = float64(p.index(value))
+	return p
+}
+
+// enumFuncP is an enum whose options are looked up as needed, for lists that
+// grow while running. They must only ever be appended to, since the value is
+// kept as an index.
+func enumFuncP(name string, value string, choices func() []string) *param {
+	p := &param{name: name, kind: enumParam, choices: choices}
+	p.value = float64(p.index(value))
+	return p
+}
+
+func boolP(name string, value bool) *param {
+	return &param{name: name, kind: boolParam, max: 1, value: boolValue(value)}
+}
+
+// readOnly marks a parameter as one the node reports, rather than one that's
+// set.
+func readOnly(p *param) *param {
+	p.readOnly = true
+	return p
+}
+
+// parse validates a value given as text.
+func (p *param) parse(s string) (float64, error) {
+	switch p.kind {
+	case enumParam:
+		if i := p.index(s); i >= 0 {
+			return float64(i), nil
+		}
+		return 0, fmt.Errorf("%s: want one of %s", p.name, strings.Join(p.opts(), ", "))
+
+	case boolParam:
+		switch strings.ToLower(s) {
+		case "on", "true", "yes", "1":
+			return 1, nil
+		case "off", "false", "no", "0":
+			return 0, nil
+		}
+		return 0, fmt.Errorf("%s: want on or off", p.name)
+	}
+
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %s", p.name, err)
+	}
+	if p.kind == intParam && f != math.Trunc(f) {
+		return 0, fmt.Errorf("%s: want a whole number", p.name)
+	}
+	if f < p.min || f > p.max {
+		return 0, fmt.Errorf("%s: want %v..%v", p.name, p.min, p.max)
+	}
+	return f, nil
+}
+
+func (p *param) format(v float64) string {
+	switch p.kind {
+	case enumParam:
+		return p.opts()[int(v)]
+	case boolParam:
+		if v != 0 {
+			return "on"
+		}
+		return "off"
+	case intParam:
+		return strconv.Itoa(int(v))
+	}
+	s := strconv.FormatFloat(v, 'f', -1, 64)
+	if p.unit != "" {
+		s += " " + p.unit
+	}
+	return s
+}
+
+func (p *param) opts() []string {
+	if p.choices != nil {
+		return p.choices()
+	}
+	return p.options
+}
+
+func (p *param) index(option string) int {
+	for i, o := range p.opts() {
+		if o == strings.ToLower(option) {
+			return i
+		}
+	}
+	return -1
+}
+
+// describe is a one-line summary of the parameter and its current value.
+func (p *param) describe(v float64) string {
+	var kind string
+	switch p.kind {
+	case floatParam:
+		kind = fmt.Sprintf("float %v..%v", p.min, p.max)
+	case intParam:
+		kind = fmt.Sprintf("int %v..%v", p.min, p.max)
+	case enumParam:
+		kind = "enum " + strings.Join(p.opts(), "|")
+	case boolParam:
+		kind = "bool"
+	}
+	if p.readOnly {
+		kind += ", read-only"
+	}
+	return fmt.Sprintf("%s (%s) = %s", p.name, kind, p.format(v))
+}
+
+// params is a node's set of parameters. It's safe for concurrent use: the
+// platform sets and gets values while the node's loop reads them.
+type params struct {
+	mtx    sync.RWMutex
+	list   []*param
+	byName map[string]*param
+
+	// changed, if set, is called after a value is set or modulated.
+	changed func(name string, value float64)
+}
+
+func newParams(list ...*param) *params {
+	ps := &params{
+		list:   list,
+		byName: map[string]*param{},
+	}
+	for _, p := range list {
+		ps.byName[p.name] = p
+	}
+	return ps
+}
+
+// set sets a parameter from text, as typed in a command.
+func (ps *params) set(name, value string) error {
+	p, ok := ps.byName[name]
+	if !ok {
+		return fmt.Errorf("no parameter %s", name)
+	}
+	if p.readOnly {
+		return fmt.Errorf("%s is read-only", name)
+	}
+	v, err := p.parse(value)
+	if err != nil {
+		return err
+	}
+	ps.store(p, v)
+	return nil
+}
+
+// modulate sets a float or int parameter, clamped to its range rather than
+// rejected, since modulation sources don't know the range.
+func (ps *params) modulate(name string, value float64) error {
+	p, ok := ps.byName[name]
+	if !ok || p.readOnly || (p.kind != floatParam && p.kind != intParam) {
+		return fmt.Errorf("no %s to modulate", name)
+	}
+	value = math.Max(p.min, math.Min(p.max, value))
+	if p.kind == intParam {
+		value = math.Floor(value + 0.5)
+	}
+	ps.store(p, value)
+	return nil
+}
+
+// report sets a value from within the node, read-only or not.
+func (ps *params) report(name string, value float64) {
+	ps.mtx.Lock()
+	defer ps.mtx.Unlock()
+	ps.byName[name].value = value
+}
+
+func (ps *params) store(p *param, v float64) {
+	ps.mtx.Lock()
+	p.value = v
+	ps.mtx.Unlock()
+	if ps.changed != nil {
+		ps.changed(p.name, v)
+	}
+}
+
+// get returns a parameter's value as text.
+func (ps *params) get(name string) (string, error) {
+	p, ok := ps.byName[name]
+	if !ok {
+		return "", fmt.Errorf("no parameter %s", name)
+	}
+	return p.format(ps.float(name)), nil
+}
+
+// float returns the value of a known parameter.
+func (ps *params) float(name string) float64 {
+	ps.mtx.RLock()
+	defer ps.mtx.RUnlock()
+	return ps.byName[name].value
+}
+
+func (ps *params) int(name string) int     { return int(ps.float(name)) }
+func (ps *params) bool(name string) bool   { return ps.float(name) != 0 }
+func (ps *params) enum(name string) string { return ps.byName[name].opts()[ps.int(name)] }
+func (ps *params) has(name string) bool    { _, ok := ps.byName[name]; return ok }
+
+func (ps *params) describe() []string {
+	lines := []string{}
+	for _, p := range ps.list {
+		lines = append(lines, p.describe(ps.float(p.name)))
+	}
+	return lines
+}
+
+// setParam handles a "<param> <value>" command for a node, logging the
+// outcome. It returns true if the parameter was set.
+func setParam(n parameterized, toks []string) bool {
+	if len(toks) < 2 {
+		log.Printf("%s: %s: not enough", n.ID(), toks[0])
+		return false
+	}
+	if err := n.parameters().set(toks[0], toks[1]); err != nil {
+		log.Printf("%s: %s", n.ID(), err)
+		return false
+	}
+	log.Printf("%s: %s %s", n.ID(), toks[0], toks[1])
+	return true
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func boolString(b bool) string {
+	if b {
+		return "on"
+	}
+	return "off"
+}
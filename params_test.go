@@ -0,0 +1,51 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestParams(t *testing.T) {
+	ps := newParams(
+		floatP("cutoff", 20, 20000, 1000, "Hz"),
+		intP("voices", 1, 8, 1),
+		enumP("mode", "lp", "lp", "hp"),
+		boolP("sync", false),
+	)
+
+	for _, c := range []struct {
+		name, value string
+		ok          bool
+		expected    string
+	}{
+		{"cutoff", "440", true, "440 Hz"},
+		{"cutoff", "5", false, "440 Hz"},
+		{"cutoff", "loud", false, "440 Hz"},
+		{"voices", "4", true, "4"},
+		{"voices", "2.5", false, "4"},
+		{"mode", "HP", true, "hp"},
+		{"mode", "bp", false, "hp"},
+		{"sync", "on", true, "on"},
+		{"sync", "maybe", false, "on"},
+	} {
+		err := ps.set(c.name, c.value)
+		if ok := err == nil; ok != c.ok {
+			t.Errorf("set %s %s: expected ok=%v, got %v", c.name, c.value, c.ok, err)
+		}
+		if got, _ := ps.get(c.name); got != c.expected {
+			t.Errorf("set %s %s: expected %q, got %q", c.name, c.value, c.expected, got)
+		}
+	}
+
+	if err := ps.modulate("cutoff", 1e6); err != nil {
+		t.Fatal(err)
+	}
+	if got := ps.float("cutoff"); got != 20000 {
+		t.Errorf("modulate: expected clamp to 20000, got %v", got)
+	}
+	if err := ps.modulate("mode", 1); err == nil {
+		t.Errorf("modulate enum: expected error")
+	}
+	if err := ps.set("resonance", "1"); err == nil {
+		t.Errorf("unknown param: expected error")
+	}
+}
@@ -2,7 +2,9 @@ package main
 
 import (
 	"errors"
+	"fmt"
 	"log"
+	"math"
 	"strconv"
 	"strings"
 
@@ -52,6 +54,7 @@ func (p *platform) stop() {
 }
 
 func (p *platform) parse(input string) {
+	raw := strings.Split(strings.TrimSpace(input), " ") // for file names
 	input = strings.TrimSpace(strings.ToLower(input))
 	toks := strings.Split(input, " ")
 	if len(toks) <= 0 {
@@ -59,23 +62,31 @@ func (p *platform) parse(input string) {
 	}
 
 	switch toks[0] {
-	case "%":
+	case "%", "%p":
 		if len(toks) < 3 {
 			log.Printf("%s: need moar", input)
 			return
 		}
-		modulo, err := strconv.ParseUint(toks[1], 10, 64)
+		var (
+			s   schedule
+			err error
+		)
+		if toks[0] == "%p" {
+			s.modulo, err = parsePulses(toks[1], p.clock.ppqn())
+		} else {
+			s, err = parseSchedule(toks[1], p.clock.ppqn()) // beats
+		}
 		if err != nil {
 			log.Printf("%s: %s", input, err)
 			return
 		}
-		command := strings.Join(toks[2:], " ")
-		p.buffer.queue(modulo, command)
-		log.Printf("queued %%%d: %s", modulo, command)
+		command := strings.Join(raw[2:], " ")
+		p.buffer.queue(s, command)
+		log.Printf("queued %% %s: %s", s, command)
 
 	case "add", "a":
-		if len(toks) != 3 {
-			log.Printf("%s: not right args", input)
+		if len(toks) < 3 {
+			log.Printf("%s: want add <type> <id> [key=value ...]", input)
 			return
 		}
 		if toks[2] == "" {
@@ -83,12 +94,9 @@ func (p *platform) parse(input string) {
 			return
 		}
 
-		var n field.Node
-		switch toks[1] {
-		case "demo":
-			n = newDemoGenerator(toks[2])
-		default:
-			log.Printf("%s: bad type", input)
+		n, err := newNode(toks[1], toks[2], raw[3:], p)
+		if err != nil {
+			log.Printf("%s: %s", input, err)
 			return
 		}
 
@@ -110,10 +118,18 @@ func (p *platform) parse(input string) {
 			log.Printf("%s: no", input)
 			return
 		}
+		n, err := p.field.Get(toks[1])
+		if err != nil {
+			log.Printf("%s: %s", input, err)
+			return
+		}
 		if err := p.field.RemoveNode(toks[1]); err != nil {
 			log.Printf("%s: %s", input, err)
 			return
 		}
+		if s, ok := n.(stopper); ok {
+			s.stop()
+		}
 		log.Printf("%s: OK, removed", input)
 
 	case "connect", "conn", "c":
@@ -153,11 +169,151 @@ func (p *platform) parse(input string) {
 			log.Printf("%s: it can't parse commands", toks[1])
 			return
 		}
-		command := strings.Join(toks[2:], " ")
+		command := strings.Join(raw[2:], " ")
 		log.Printf("sending to %s: %s", toks[1], command)
 		p.parse(command)
 
+	case "set":
+		if len(toks) != 4 {
+			log.Printf("%s: want set <node> <param> <value>", input)
+			return
+		}
+		n, err := p.parameterized(toks[1])
+		if err != nil {
+			log.Printf("%s: %s", input, err)
+			return
+		}
+		setParam(n, toks[2:])
+
+	case "get", "params", "response", "types", "position":
+		reply, _ := p.query(input)
+		log.Printf("%s: %s", input, reply)
+
+	case "tuning", "tune":
+		t, err := parseTuning(globalTuning.get(), raw[1:])
+		if err != nil {
+			log.Printf("%s: %s", input, err)
+			return
+		}
+		globalTuning.set(t)
+		log.Printf("%s: OK, %s", input, t)
+
+	case "wave":
+		if len(toks) < 4 || toks[1] != "define" {
+			log.Printf("%s: want wave define <name> <expression>", input)
+			return
+		}
+		name, src := toks[2], strings.Trim(strings.Join(toks[3:], " "), `"'`)
+		if name == "sh" {
+			log.Printf("%s: sh is the lfo's sample and hold", input)
+			return
+		}
+		w, err := exprWaveform(src)
+		if err != nil {
+			log.Printf("%s: %s", input, err)
+			return
+		}
+		if err := waveforms.define(name, w); err != nil {
+			log.Printf("%s: %s", input, err)
+			return
+		}
+		log.Printf("wave %s: defined", name)
+
 	default:
 		log.Printf("%s: aroo", input)
 	}
 }
+
+// query answers a question, for a client that's waiting to hear back. ok is
+// false if input isn't a query, and should be parsed as a command instead.
+//
+//	get <node> <param>   the current value of a parameter
+//	params <node>        every parameter of a node
+//	types                every type of node that can be added
+//	position             where the clock is, as "<bar> <beat> <tick>"
+//	response <node> [n]  magnitude response at n log-spaced frequencies, as
+//	                     lines of "<hz> <dB>"
+func (p *platform) query(input string) (reply string, ok bool) {
+	input = strings.TrimSpace(strings.ToLower(input))
+	toks := strings.Split(input, " ")
+
+	switch toks[0] {
+	case "get":
+		if len(toks) != 3 {
+			return "error: want get <node> <param>", true
+		}
+		n, err := p.parameterized(toks[1])
+		if err != nil {
+			return "error: " + err.Error(), true
+		}
+		v, err := n.parameters().get(toks[2])
+		if err != nil {
+			return "error: " + err.Error(), true
+		}
+		return v, true
+
+	case "params":
+		if len(toks) != 2 {
+			return "error: want params <node>", true
+		}
+		n, err := p.parameterized(toks[1])
+		if err != nil {
+			return "error: " + err.Error(), true
+		}
+		return strings.Join(n.parameters().describe(), "\n"), true
+
+	case "types":
+		return strings.Join(describeNodeTypes(), "\n"), true
+
+	case "position":
+		return p.clock.position().String(), true
+
+	case "response":
+		if len(toks) < 2 || len(toks) > 3 {
+			return "error: want response <node> [points]", true
+		}
+		points := 64
+		if len(toks) == 3 {
+			var err error
+			if points, err = strconv.Atoi(toks[2]); err != nil || points < 2 || points > 1024 {
+				return "error: want 2..1024 points", true
+			}
+		}
+		n, err := p.field.Get(toks[1])
+		if err != nil {
+			return "error: " + err.Error(), true
+		}
+		r, ok := n.(responder)
+		if !ok {
+			return fmt.Sprintf("error: %s has no response", toks[1]), true
+		}
+		hz := logSpaced(20, 20000, points)
+		lines := []string{}
+		for i, db := range r.response(hz) {
+			lines = append(lines, fmt.Sprintf("%.1f %.2f", hz[i], db))
+		}
+		return strings.Join(lines, "\n"), true
+	}
+	return "", false
+}
+
+// logSpaced returns n frequencies from lo to hi, evenly spaced in octaves.
+func logSpaced(lo, hi float64, n int) []float64 {
+	hz := make([]float64, n)
+	for i := range hz {
+		hz[i] = lo * math.Pow(hi/lo, float64(i)/float64(n-1))
+	}
+	return hz
+}
+
+func (p *platform) parameterized(id string) (parameterized, error) {
+	n, err := p.field.Get(id)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", id, err)
+	}
+	pn, ok := n.(parameterized)
+	if !ok {
+		return nil, fmt.Errorf("%s has no parameters", id)
+	}
+	return pn, nil
+}
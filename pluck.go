@@ -0,0 +1,297 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"math/rand"
+	"strings"
+
+	"github.com/peterbourgon/field"
+)
+
+// pluckGenerator is a Karplus-Strong plucked string. Each key down fills a
+// delay line, one period of the note long, with a burst of noise; the line
+// then feeds back through a gentle low-pass, so the burst settles into a
+// decaying, pitched tone.
+type pluckGenerator struct {
+	id            string
+	params        *params
+	keyDownEvents chan keyEvent
+	keyUpEvents   chan keyEvent
+	plucked       map[float64]*pluckString // by MIDI key
+	tuning        nodeTuning
+	retunes       chan retuneRequest
+	connects      chan connectRequest
+	disconnects   chan string
+	levels        chan levelRequest
+	outputs       outputs
+	quit          chan chan struct{}
+}
+
+func init() {
+	registerNodeType(nodeType{
+		name:        "pluck",
+		description: "Karplus-Strong plucked string",
+		new:         func(id string, p *platform) field.Node { return newPluckGenerator(id) },
+	})
+}
+
+func newPluckGenerator(id string) *pluckGenerator {
+	g := &pluckGenerator{
+		id: id,
+		params: newParams(
+			floatP("damping", 0, 1, 0.5, ""),    // high-frequency loss in the loop
+			floatP("brightness", 0, 1, 0.7, ""), // of the noise burst
+			floatP("decay", 0.1, 30, 3, "s"),    // to -60 dB, while held
+			floatP("release", 0.01, 5, 0.2, "s"),
+		),
+		keyDownEvents: make(chan keyEvent),
+		keyUpEvents:   make(chan keyEvent),
+		plucked:       map[float64]*pluckString{},
+		tuning:        nodeTuning{},
+		retunes:       make(chan retuneRequest),
+		connects:      make(chan connectRequest),
+		disconnects:   make(chan string),
+		levels:        make(chan levelRequest),
+		outputs:       outputs{},
+		quit:          make(chan chan struct{}),
+	}
+	go g.loop()
+	return g
+}
+
+func (g *pluckGenerator) stop() {
+	q := make(chan struct{})
+	g.quit <- q
+	<-q
+}
+
+func (g *pluckGenerator) loop() {
+	log.Printf("%s: started", g.ID())
+	defer log.Printf("%s: done", g.ID())
+
+	for {
+		if g.outputs.idle() {
+			g.outputs.load(g.nextBuffer())
+		}
+		c, buf := g.outputs.next()
+
+		select {
+		case c <- buf:
+			g.outputs.sent()
+
+		case <-g.outputs.expired():
+			g.outputs.skip()
+
+		case k := <-g.keyDownEvents:
+			log.Printf("%s: pluck %v (%.2f)", g.ID(), k.midi, k.velocity)
+			hz := g.tuning.get().hz(k.midi)
+			if hz <= 0 {
+				continue // unmapped
+			}
+			g.plucked[k.midi] = newPluckString(
+				float64(hz),
+				k.velocity,
+				g.params.float("brightness"),
+				g.params.float("damping"),
+			)
+
+		case k := <-g.keyUpEvents:
+			if k.midi == 0 {
+				g.plucked = map[float64]*pluckString{} // reset
+				continue
+			}
+			if s, ok := g.plucked[k.midi]; ok {
+				s.held = false
+			}
+
+		case r := <-g.retunes:
+			r.e <- g.tuning.apply(r.toks)
+
+		case r := <-g.connects:
+			if err := g.outputs.connect(g.ID(), r.r); err != nil {
+				r.e <- fmt.Errorf("%s %s", g.ID(), err)
+				continue
+			}
+			r.e <- nil
+			log.Printf("%s → %s", g.ID(), r.r.ID())
+
+		case id := <-g.disconnects:
+			if err := g.outputs.disconnect(id); err != nil {
+				log.Printf("%s: disconnect: %s (bug in field)", g.ID(), err)
+				continue
+			}
+			log.Printf("%s ✕ %s", g.ID(), id)
+
+		case r := <-g.levels:
+			r.e <- g.outputs.setLevel(r.id, r.level)
+
+		case q := <-g.quit:
+			g.outputs.closeAll()
+			close(q)
+			return
+		}
+	}
+}
+
+func (g *pluckGenerator) nextBuffer() []float32 {
+	buf := make([]float32, bufSz)
+	decay, release := g.params.float("decay"), g.params.float("release")
+	for key, s := range g.plucked {
+		t60 := decay
+		if !s.held {
+			t60 = release
+		}
+		if !s.render(buf, t60) {
+			delete(g.plucked, key)
+		}
+	}
+	return buf
+}
+
+func (g *pluckGenerator) parse(input string) {
+	raw := strings.Split(strings.TrimSpace(input), " ") // for file names
+	input = strings.TrimSpace(strings.ToLower(input))
+	toks := strings.Split(input, " ")
+	if len(toks) <= 0 {
+		log.Printf("%s: parse empty", g.ID())
+		return
+	}
+
+	switch toks[0] {
+	case "keydown", "kd", "down", "d":
+		k, err := parseKeyEvent(toks)
+		if err != nil {
+			log.Printf("%s: %s: %s", g.ID(), input, err)
+			return
+		}
+		g.keyDown(k)
+
+	case "keyup", "ku", "up", "u":
+		k, err := parseKeyEvent(toks)
+		if err != nil {
+			log.Printf("%s: %s: %s", g.ID(), input, err)
+			return
+		}
+		g.keyUp(k)
+
+	case "reset":
+		g.keyUp(keyEvent{})
+
+	case "level", "lvl":
+		requestLevel(g.ID(), g.levels, toks)
+
+	case "tuning", "tune":
+		requestRetune(g.ID(), g.retunes, raw)
+
+	default:
+		if !g.params.has(toks[0]) {
+			log.Printf("%s: %s: aroo", g.ID(), input)
+			return
+		}
+		setParam(g, toks)
+	}
+}
+
+func (g *pluckGenerator) ID() string          { return g.id }
+func (g *pluckGenerator) parameters() *params { return g.params }
+
+func (g *pluckGenerator) modulate(param string, value float64) error {
+	return g.params.modulate(param, value)
+}
+
+func (g *pluckGenerator) keyDown(k keyEvent) { g.keyDownEvents <- k }
+func (g *pluckGenerator) keyUp(k keyEvent)   { g.keyUpEvents <- k }
+
+func (g *pluckGenerator) Connect(n field.Node) error {
+	r, ok := n.(audioReceiver)
+	if !ok {
+		return fmt.Errorf("%s not audioReceiver", n.ID())
+	}
+	req := connectRequest{r, make(chan error)}
+	g.connects <- req
+	return <-req.e
+}
+
+func (g *pluckGenerator) Disconnect(n field.Node) {
+	if _, ok := n.(audioReceiver); !ok {
+		log.Printf("%s not audioReceiver", n.ID())
+		return
+	}
+	g.disconnects <- n.ID()
+}
+
+func (g *pluckGenerator) Connection(n field.Node) error {
+	return controlConnection(g, n)
+}
+
+func (g *pluckGenerator) Disconnection(n field.Node) {}
+
+// pluckString is one sounding string: a delay line with a two-point
+// averaging filter in its feedback path.
+type pluckString struct {
+	line  []float32
+	w     int     // write position
+	delay float64 // samples, fractional
+	blend float32 // averaging filter weight, 0..0.5
+	prev  float32 // last sample read, for the filter
+	hz    float64
+	held  bool
+}
+
+// newPluckString excites a string at hz. Velocity scales the burst, and
+// together with brightness, how much of its top end survives.
+func newPluckString(hz float64, velocity float32, brightness, damping float64) *pluckString {
+	blend := 0.5 * damping
+	delay := sRate/hz - blend // the filter adds blend samples of delay
+	s := &pluckString{
+		line:  make([]float32, int(math.Ceil(delay))+2),
+		delay: delay,
+		blend: float32(blend),
+		hz:    hz,
+		held:  true,
+	}
+
+	// A one-pole low-pass on the noise; duller for softer notes. It never
+	// closes all the way, or there'd be no burst at all.
+	a := float32(0.95 * (1 - brightness*(0.5+0.5*float64(velocity))))
+	var lp float32
+	for i := range s.line {
+		lp = (1-a)*(2*rand.Float32()-1) + a*lp
+		s.line[i] = velocity * lp
+	}
+	s.w = 0
+	return s
+}
+
+// render adds the next bufSz samples of the string to buf. It decays to
+// -60 dB in t60 seconds, and returns false once it's inaudible, held or not.
+func (s *pluckString) render(buf []float32, t60 float64) bool {
+	g := float32(math.Pow(10, -3/(t60*s.hz))) // per trip around the loop
+	n := len(s.line)
+	var peak float32
+	for i := range buf {
+		// Read delay samples behind the write position.
+		r := float64(s.w) - s.delay
+		for r < 0 {
+			r += float64(n)
+		}
+		i0 := int(r)
+		frac := float32(r - float64(i0))
+		x := (1-frac)*s.line[i0%n] + frac*s.line[(i0+1)%n]
+
+		y := g * ((1-s.blend)*x + s.blend*s.prev)
+		s.prev = x
+		s.line[s.w] = y
+		s.w = (s.w + 1) % n
+
+		buf[i] += y
+		if y > peak {
+			peak = y
+		} else if -y > peak {
+			peak = -y
+		}
+	}
+	return peak > 1e-4
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestPluckString(t *testing.T) {
+	for _, c := range []struct {
+		hz                  float64
+		velocity            float32
+		brightness, damping float64
+	}{
+		{110, 1, 0, 0.5},
+		{110, 1, 1, 0.5},
+		{440, 0.2, 0, 0},
+		{440, 0.2, 1, 1},
+		{1760, 1, 0.7, 0.5},
+	} {
+		s := newPluckString(c.hz, c.velocity, c.brightness, c.damping)
+		first, last, buffers := 0.0, 0.0, 0
+		for ; buffers < int(5*sRate/bufSz); buffers++ {
+			buf := make([]float32, bufSz)
+			alive := s.render(buf, 0.5)
+			if buffers == 0 {
+				first = rms(buf)
+			}
+			last = rms(buf)
+			if !alive {
+				break
+			}
+		}
+		if first < 0.005 {
+			t.Errorf("%+v: expected an audible pluck, got %.5f RMS", c, first)
+		}
+		if last >= first {
+			t.Errorf("%+v: expected it to decay from %.5f RMS, got %.5f", c, first, last)
+		}
+		if seconds := float64(buffers*bufSz) / sRate; seconds > 2 {
+			t.Errorf("%+v: expected it gone soon after its 0.5s t60, still going at %.1fs", c, seconds)
+		}
+	}
+}
+
+func rms(buf []float32) float64 {
+	var sum float64
+	for _, x := range buf {
+		sum += float64(x) * float64(x)
+	}
+	return math.Sqrt(sum / float64(len(buf)))
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/peterbourgon/field"
+)
+
+// nodeType is a kind of node that can be added with
+//
+//	add <type> <id> [key=value ...]
+//
+// Node types register themselves from an init function in their own file,
+// so adding one means adding a file, not editing the platform.
+//
+// Node types from other packages register with package node instead, and
+// are added as external nodes (see external.go).
+//
+// Creation arguments set any of the node's params, after it's made; args
+// declares any more that a type takes, like load=<file>.
+type nodeType struct {
+	name        string
+	aliases     []string
+	description string
+	args        []nodeArg // beyond params
+	new         func(id string, p *platform) field.Node
+}
+
+// nodeArg is a creation argument that isn't a param. apply is given the node
+// as made by its type's new, and the value; an error stops the node again.
+type nodeArg struct {
+	name  string
+	what  string // the kind of value, for types, like <file>
+	apply func(n field.Node, value string) error
+}
+
+var nodeTypes = map[string]*nodeType{} // by name and alias
+
+// registerNodeType adds a node type. It panics on a name that's taken, since
+// that's a mistake in the build.
+func registerNodeType(t nodeType) {
+	for _, name := range append([]string{t.name}, t.aliases...) {
+		if _, ok := nodeTypes[name]; ok {
+			panic(fmt.Sprintf("node type %s registered twice", name))
+		}
+		nodeTypes[name] = &t
+	}
+}
+
+// newNode makes a node of the named type, and applies its creation
+// arguments. If any of them are bad, the node is stopped again.
+func newNode(typ, id string, args []string, p *platform) (field.Node, error) {
+	t, ok := nodeTypes[strings.ToLower(typ)]
+	if !ok {
+		return nil, fmt.Errorf("no type %s (see types)", typ)
+	}
+
+	type arg struct{ key, value string }
+	parsed := []arg{}
+	for _, a := range args {
+		i := strings.Index(a, "=")
+		if i <= 0 {
+			return nil, fmt.Errorf("%s: want key=value", a)
+		}
+		parsed = append(parsed, arg{strings.ToLower(a[:i]), a[i+1:]})
+	}
+
+	n := t.new(id, p)
+	for _, a := range parsed {
+		if err := t.apply(n, a.key, a.value); err != nil {
+			if s, ok := n.(stopper); ok {
+				s.stop()
+			}
+			return nil, fmt.Errorf("%s=%s: %s", a.key, a.value, err)
+		}
+	}
+	return n, nil
+}
+
+func (t *nodeType) apply(n field.Node, key, value string) error {
+	if pn, ok := n.(parameterized); ok && pn.parameters().has(key) {
+		return pn.parameters().set(key, value)
+	}
+	for _, a := range t.args {
+		if a.name == key {
+			return a.apply(n, value)
+		}
+	}
+	return fmt.Errorf("%s takes no %s", t.name, key)
+}
+
+// describeNodeTypes is a line for each node type, in order.
+func describeNodeTypes() []string {
+	names := []string{}
+	for name, t := range nodeTypes {
+		if name == t.name {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	lines := []string{}
+	for _, name := range names {
+		t := nodeTypes[name]
+		line := name
+		if len(t.aliases) > 0 {
+			line += " (" + strings.Join(t.aliases, ", ") + ")"
+		}
+		line += ": " + t.description
+		if len(t.args) > 0 {
+			args := []string{}
+			for _, a := range t.args {
+				args = append(args, a.name+"="+a.what)
+			}
+			line += "; args " + strings.Join(args, " ") + ", and params"
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
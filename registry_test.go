@@ -0,0 +1,66 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNewNode(t *testing.T) {
+	for _, c := range []struct {
+		typ      string
+		args     []string
+		expected map[string]string // param values; nil = want an error
+	}{
+		{"synth", []string{"wave=saw", "unison=4"}, map[string]string{"wave": "saw", "unison": "4"}},
+		{"synth", []string{"wave=saw", "voices=8"}, map[string]string{"wave": "saw", "voices": "8"}},
+		{"filter", []string{"mode=band", "cutoff=500"}, map[string]string{"mode": "band", "cutoff": "500 Hz"}},
+		{"conv", nil, map[string]string{"mix": "0.3"}},
+		{"comp", []string{"sidechain=kick", "ratio=8"}, map[string]string{"ratio": "8"}},
+		{"conv", []string{"load=missing.wav"}, nil},
+		{"grain", []string{"load=missing.wav"}, nil},
+		{"nope", nil, nil},
+		{"filter", []string{"cutoff"}, nil},
+		{"filter", []string{"cutoff=5"}, nil},
+		{"filter", []string{"voices=8"}, nil},
+	} {
+		n, err := newNode(c.typ, "node", c.args, nil)
+		if c.expected == nil {
+			if err == nil {
+				t.Errorf("%s %v: expected error", c.typ, c.args)
+				n.(stopper).stop()
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s %v: %s", c.typ, c.args, err)
+			continue
+		}
+		for name, expected := range c.expected {
+			if got, _ := n.(parameterized).parameters().get(name); got != expected {
+				t.Errorf("%s %v: expected %s %s, got %s", c.typ, c.args, name, expected, got)
+			}
+		}
+		n.(stopper).stop()
+	}
+}
+
+func TestDescribeNodeTypes(t *testing.T) {
+	lines := describeNodeTypes()
+	for _, prefix := range []string{"demo (synth): ", "convolver (conv): ", "grain: ", "comp: "} {
+		found := false
+		for _, line := range lines {
+			found = found || strings.HasPrefix(line, prefix)
+		}
+		if !found {
+			t.Errorf("expected a line starting %q in %v", prefix, lines)
+		}
+	}
+}
+
+func TestDescribeNodeArgs(t *testing.T) {
+	for _, line := range describeNodeTypes() {
+		if strings.HasPrefix(line, "convolver (conv): ") && !strings.Contains(line, "; args load=<file>, and params") {
+			t.Errorf("expected the convolver's load arg in %q", line)
+		}
+	}
+}
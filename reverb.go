@@ -0,0 +1,110 @@
+package main
+
+import (
+	"math"
+
+	"github.com/peterbourgon/field"
+)
+
+const maxPredelay = 0.5 // seconds
+
+// Freeverb's tunings, in samples at 44.1 kHz.
+var (
+	reverbCombs     = []int{1116, 1188, 1277, 1356, 1422, 1491, 1557, 1617}
+	reverbAllpasses = []int{556, 441, 341, 225}
+)
+
+// reverb is a Freeverb-style reverb: parallel damped comb filters for the
+// decay, into allpass filters for diffusion, after a pre-delay.
+//
+// Use it inline, with some mix, or as a send: connect several nodes to it at
+// their own levels, set mix 1, and connect it to the mixer.
+//
+// Width is how far apart Freeverb spreads its two sides. The output is mono
+// for now, so it has no effect yet; it's there so patches can set it ahead
+// of stereo.
+type reverb struct {
+	*effect
+	pre       []float32 // pre-delay line
+	w         int
+	combs     []*reverbComb
+	allpasses []*reverbAllpass
+}
+
+type reverbComb struct {
+	buf  []float32
+	i    int
+	damp float32 // state of the damping filter
+}
+
+type reverbAllpass struct {
+	buf []float32
+	i   int
+}
+
+func init() {
+	registerNodeType(nodeType{
+		name:        "reverb",
+		description: "Freeverb-style reverb effect",
+		new:         func(id string, p *platform) field.Node { return newReverb(id) },
+	})
+}
+
+func newReverb(id string) *reverb {
+	r := &reverb{pre: make([]float32, int(maxPredelay*sRate)+1)}
+	for _, n := range reverbCombs {
+		r.combs = append(r.combs, &reverbComb{buf: make([]float32, n)})
+	}
+	for _, n := range reverbAllpasses {
+		r.allpasses = append(r.allpasses, &reverbAllpass{buf: make([]float32, n)})
+	}
+	r.effect = newEffect(id, newParams(
+		floatP("size", 0, 1, 0.5, ""),
+		floatP("damping", 0, 1, 0.5, ""),
+		floatP("predelay", 0, maxPredelay*1000, 10, "ms"),
+		floatP("mix", 0, 1, 0.25, ""),
+		floatP("width", 0, 1, 1, ""), // no effect while the output's mono
+	), r)
+	return r
+}
+
+func (r *reverb) process(in []float32) []float32 {
+	var (
+		feedback = float32(0.7 + 0.28*r.params.float("size"))
+		damping  = float32(0.4 * r.params.float("damping"))
+		pre      = int(math.Min(r.params.float("predelay")/1000, maxPredelay) * sRate)
+		n        = len(r.pre)
+	)
+	wet := make([]float32, len(in))
+	for i, x := range in {
+		r.pre[r.w] = x
+		d := r.pre[(r.w-pre+n)%n]
+		r.w = (r.w + 1) % n
+
+		d *= 0.015 // Freeverb's fixed input gain; the combs add up
+		var y float32
+		for _, c := range r.combs {
+			y += c.process(d, feedback, damping)
+		}
+		for _, a := range r.allpasses {
+			y = a.process(y)
+		}
+		wet[i] = 3 * y
+	}
+	return mix(in, wet, float32(r.params.float("mix")))
+}
+
+func (c *reverbComb) process(x, feedback, damping float32) float32 {
+	y := c.buf[c.i]
+	c.damp = y*(1-damping) + c.damp*damping
+	c.buf[c.i] = x + c.damp*feedback
+	c.i = (c.i + 1) % len(c.buf)
+	return y
+}
+
+func (a *reverbAllpass) process(x float32) float32 {
+	b := a.buf[a.i]
+	a.buf[a.i] = x + 0.5*b
+	a.i = (a.i + 1) % len(a.buf)
+	return b - x
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestReverbTail(t *testing.T) {
+	r := newReverb("reverb")
+	defer r.stop()
+	r.parse("mix 1")
+	r.parse("predelay 0")
+
+	// An impulse rings on, and dies away.
+	energies := []float64{}
+	for n := 0; n < 200; n++ {
+		in := make([]float32, bufSz)
+		if n == 0 {
+			in[0] = 1
+		}
+		var e float64
+		for _, v := range r.process(in) {
+			e += float64(v) * float64(v)
+		}
+		energies = append(energies, e)
+	}
+	if energies[1] <= 0 {
+		t.Errorf("expected a tail, got silence")
+	}
+	if last := energies[len(energies)-1]; last >= energies[1]/1000 || math.IsNaN(last) {
+		t.Errorf("expected the tail to die away, got %g after %g", last, energies[1])
+	}
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// scale is a Scala (.scl) scale. Degree 0 is the implicit 1/1; cents holds
+// degrees 1..n, and the last one is the period (usually the octave).
+//
+// See http://www.huygens-fokker.org/scala/scl_format.html
+type scale struct {
+	description string
+	cents       []float64
+}
+
+var twelveTone = scale{
+	description: "12-TET",
+	cents:       []float64{100, 200, 300, 400, 500, 600, 700, 800, 900, 1000, 1100, 1200},
+}
+
+// degree returns the pitch of any scale degree in cents, including degrees
+// outside of [0..n] which wrap around the period.
+func (s *scale) degree(d int) float64 {
+	n := len(s.cents)
+	periods, d := floorDiv(d, n)
+	c := float64(periods) * s.cents[n-1]
+	if d > 0 {
+		c += s.cents[d-1]
+	}
+	return c
+}
+
+func loadScale(filename string) (*scale, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return readScale(f)
+}
+
+func readScale(r io.Reader) (*scale, error) {
+	lines, err := scalaLines(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(lines) < 2 {
+		return nil, fmt.Errorf("scl: too short")
+	}
+
+	s := &scale{description: strings.TrimSpace(lines[0])}
+	n, err := strconv.Atoi(firstField(lines[1]))
+	if err != nil {
+		return nil, fmt.Errorf("scl: note count: %s", err)
+	}
+	if n <= 0 {
+		return nil, fmt.Errorf("scl: no notes")
+	}
+	if len(lines)-2 < n {
+		return nil, fmt.Errorf("scl: %d notes declared, %d given", n, len(lines)-2)
+	}
+
+	for _, line := range lines[2 : 2+n] {
+		c, err := parsePitch(firstField(line))
+		if err != nil {
+			return nil, fmt.Errorf("scl: %s", err)
+		}
+		s.cents = append(s.cents, c)
+	}
+	if s.cents[n-1] <= 0 {
+		return nil, fmt.Errorf("scl: period must be above 1/1")
+	}
+	return s, nil
+}
+
+// parsePitch parses a Scala pitch, which is in cents if it contains a period,
+// and a ratio (3/2, or 2) otherwise.
+func parsePitch(s string) (float64, error) {
+	if strings.Contains(s, ".") {
+		return strconv.ParseFloat(s, 64)
+	}
+
+	num, den := s, "1"
+	if i := strings.Index(s, "/"); i >= 0 {
+		num, den = s[:i], s[i+1:]
+	}
+	n, err := strconv.ParseUint(num, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	d, err := strconv.ParseUint(den, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	if n == 0 || d == 0 {
+		return 0, fmt.Errorf("%s: bad ratio", s)
+	}
+	return 1200 * math.Log2(float64(n)/float64(d)), nil
+}
+
+// keymap is a Scala keyboard mapping (.kbm), which says which scale degree
+// sits on which MIDI key, and which key has a known frequency.
+//
+// See http://www.huygens-fokker.org/scala/help.htm#mappings
+type keymap struct {
+	first, last int     // keys outside this range are unmapped (0, 0 = all)
+	middle      int     // key where degree 0 is
+	refKey      int     // key with a known frequency
+	refHz       float64 // frequency of refKey
+	period      int     // degree of the formal octave, 0 = the scale's own
+	mapping     []int   // degree for each key in a repeat, -1 = unmapped; empty = linear
+}
+
+// cents returns the pitch of a key relative to the middle key, and whether
+// the key is mapped at all.
+func (m *keymap) cents(s *scale, key int) (float64, bool) {
+	if (m.first != 0 || m.last != 0) && (key < m.first || key > m.last) {
+		return 0, false
+	}
+	if len(m.mapping) <= 0 {
+		return s.degree(key - m.middle), true
+	}
+
+	repeats, i := floorDiv(key-m.middle, len(m.mapping))
+	d := m.mapping[i]
+	if d < 0 {
+		return 0, false
+	}
+	period := s.cents[len(s.cents)-1]
+	if m.period > 0 {
+		period = s.degree(m.period)
+	}
+	return float64(repeats)*period + s.degree(d), true
+}
+
+func loadKeymap(filename string) (*keymap, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return readKeymap(f)
+}
+
+func readKeymap(r io.Reader) (*keymap, error) {
+	lines, err := scalaLines(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(lines) < 7 {
+		return nil, fmt.Errorf("kbm: too short")
+	}
+
+	ints := make([]int, 7)
+	for i := range ints {
+		if i == 5 {
+			continue // the reference frequency
+		}
+		if ints[i], err = strconv.Atoi(firstField(lines[i])); err != nil {
+			return nil, fmt.Errorf("kbm: line %d: %s", i+1, err)
+		}
+	}
+	refHz, err := strconv.ParseFloat(firstField(lines[5]), 64)
+	if err != nil {
+		return nil, fmt.Errorf("kbm: reference frequency: %s", err)
+	}
+	if refHz <= 0 || refHz > maxRefHz {
+		return nil, fmt.Errorf("kbm: want a reference frequency up to %v Hz", maxRefHz)
+	}
+
+	m := &keymap{
+		first:  ints[1],
+		last:   ints[2],
+		middle: ints[3],
+		refKey: ints[4],
+		refHz:  refHz,
+		period: ints[6],
+	}
+
+	size := ints[0]
+	if size < 0 {
+		return nil, fmt.Errorf("kbm: bad map size")
+	}
+	for i := 0; i < size; i++ {
+		line := 7 + i
+		if line >= len(lines) {
+			m.mapping = append(m.mapping, -1) // missing entries are unmapped
+			continue
+		}
+		field := firstField(lines[line])
+		if field == "x" || field == "X" {
+			m.mapping = append(m.mapping, -1)
+			continue
+		}
+		d, err := strconv.Atoi(field)
+		if err != nil {
+			return nil, fmt.Errorf("kbm: mapping %d: %s", i, err)
+		}
+		m.mapping = append(m.mapping, d)
+	}
+	return m, nil
+}
+
+// scalaLines returns the lines of a Scala file, less comments.
+func scalaLines(r io.Reader) ([]string, error) {
+	lines := []string{}
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		line := strings.TrimRight(sc.Text(), "\r")
+		if strings.HasPrefix(line, "!") {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return lines, sc.Err()
+}
+
+func firstField(s string) string {
+	f := strings.Fields(s)
+	if len(f) <= 0 {
+		return ""
+	}
+	return f[0]
+}
+
+// floorDiv divides rounding towards negative infinity, so the remainder is
+// always in [0..d).
+func floorDiv(n, d int) (int, int) {
+	q, r := n/d, n%d
+	if r < 0 {
+		q, r = q-1, r+d
+	}
+	return q, r
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// tempoChange is a change to a new tempo, at once or over some bars or beats.
+type tempoChange struct {
+	bpm  float64
+	over float64 // 0 to jump
+	bars bool    // if over is in bars, not beats
+}
+
+// parseTempoChange parses "<bpm> [over <n> bars|beats]".
+func parseTempoChange(toks []string) (tempoChange, error) {
+	if len(toks) != 1 && len(toks) != 4 {
+		return tempoChange{}, fmt.Errorf("want <bpm> [over <n> bars|beats]")
+	}
+	bpm, err := strconv.ParseFloat(toks[0], 64)
+	if err != nil || bpm < 1 || bpm > 999 {
+		return tempoChange{}, fmt.Errorf("%s: want 1..999 bpm", toks[0])
+	}
+	tc := tempoChange{bpm: bpm}
+	if len(toks) == 1 {
+		return tc, nil
+	}
+	if toks[1] != "over" {
+		return tempoChange{}, fmt.Errorf("want <bpm> over <n> bars|beats")
+	}
+	if tc.over, err = strconv.ParseFloat(toks[2], 64); err != nil || tc.over <= 0 {
+		return tempoChange{}, fmt.Errorf("%s: want a length over 0", toks[2])
+	}
+	switch toks[3] {
+	case "bar", "bars":
+		tc.bars = true
+	case "beat", "beats":
+	default:
+		return tempoChange{}, fmt.Errorf("%s: want bars or beats", toks[3])
+	}
+	return tc, nil
+}
+
+func (tc tempoChange) String() string {
+	switch {
+	case tc.over <= 0:
+		return fmt.Sprintf("%v bpm", tc.bpm)
+	case tc.bars:
+		return fmt.Sprintf("%v bpm over %v bars", tc.bpm, tc.over)
+	}
+	return fmt.Sprintf("%v bpm over %v beats", tc.bpm, tc.over)
+}
+
+// pulses is how long a change takes, in pulses, from a position. Beats are
+// the meter's, so over 4 beats in 7/8 is 4 eighths.
+func (tc tempoChange) pulses(pos position) uint64 {
+	length := tc.over * float64(pos.meter.beatPulses(pos.ppqn))
+	if tc.bars {
+		length = tc.over * float64(pos.meter.barPulses(pos.ppqn))
+	}
+	return uint64(math.Max(1, math.Floor(length+0.5)))
+}
+
+// tempoRamp is a tempo changing linearly in pulses, from pulse start to end.
+type tempoRamp struct {
+	from, to   float64 // bpm
+	start, end uint64
+}
+
+// bpm is the tempo at pulse n.
+func (r *tempoRamp) bpm(n uint64) float64 {
+	switch {
+	case n <= r.start:
+		return r.from
+	case n >= r.end:
+		return r.to
+	}
+	return r.from + (r.to-r.from)*float64(n-r.start)/float64(r.end-r.start)
+}
+
+// duration is the time from pulse n to the next. The tempo changes smoothly
+// through the pulse, so that's the integral of 60/bpm over it, in beats.
+func (r *tempoRamp) duration(n uint64, ppqn int) time.Duration {
+	from, to := r.bpm(n), r.bpm(n+1)
+	beats, seconds := 1/float64(ppqn), 0.0
+	if math.Abs(to-from) < 1e-9 {
+		seconds = 60 / from * beats
+	} else {
+		slope := (to - from) / beats // bpm per beat
+		seconds = 60 / slope * math.Log(to/from)
+	}
+	return time.Duration(math.Round(seconds * float64(time.Second))) // so errors don't all go one way
+}
+
+// rescale moves the ramp to another resolution.
+func (r *tempoRamp) rescale(from, to int) {
+	r.start = r.start * uint64(to) / uint64(from)
+	r.end = r.end * uint64(to) / uint64(from)
+}
+
+// tempoMap is tempo changes by the bar they start on.
+type tempoMap map[int]tempoChange
+
+func (m tempoMap) describe() []string {
+	bars := []int{}
+	for bar := range m {
+		bars = append(bars, bar)
+	}
+	sort.Ints(bars)
+	lines := []string{}
+	for _, bar := range bars {
+		lines = append(lines, fmt.Sprintf("bar %d: %s", bar, m[bar]))
+	}
+	return lines
+}
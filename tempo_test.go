@@ -0,0 +1,107 @@
+package main
+
+import (
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseTempoChange(t *testing.T) {
+	for _, c := range []struct {
+		input    string
+		expected tempoChange // zero for an error
+	}{
+		{"140", tempoChange{bpm: 140}},
+		{"140 over 8 bars", tempoChange{bpm: 140, over: 8, bars: true}},
+		{"90 over 1 bar", tempoChange{bpm: 90, over: 1, bars: true}},
+		{"90 over 6 beats", tempoChange{bpm: 90, over: 6}},
+		{"90 over 0 beats", tempoChange{}},
+		{"90 over 6 weeks", tempoChange{}},
+		{"90 under 6 beats", tempoChange{}},
+		{"90 over 6", tempoChange{}},
+		{"0", tempoChange{}},
+		{"fast", tempoChange{}},
+	} {
+		got, err := parseTempoChange(strings.Split(c.input, " "))
+		if c.expected == (tempoChange{}) {
+			if err == nil {
+				t.Errorf("%s: expected an error, got %s", c.input, got)
+			}
+			continue
+		}
+		if err != nil || got != c.expected {
+			t.Errorf("%s: expected %s, got %s (%v)", c.input, c.expected, got, err)
+		}
+	}
+}
+
+func TestTempoChangePulses(t *testing.T) {
+	pos := position{bar: 3, beat: 1, meter: meter{7, 8}, ppqn: 96}
+	for _, c := range []struct {
+		tc       tempoChange
+		expected uint64
+	}{
+		{tempoChange{bpm: 140, over: 2, bars: true}, 2 * 7 * 48},
+		{tempoChange{bpm: 140, over: 3}, 3 * 48},
+		{tempoChange{bpm: 140, over: 0.5}, 24},
+	} {
+		if got := c.tc.pulses(pos); got != c.expected {
+			t.Errorf("%s in %s: expected %d pulses, got %d", c.tc, pos.meter, c.expected, got)
+		}
+	}
+}
+
+func TestTempoRamp(t *testing.T) {
+	for _, c := range []struct {
+		from, to float64
+		beats    int
+		ppqn     int
+	}{
+		{120, 140, 4, 24},
+		{140, 70, 32, 96},
+		{60, 180, 8, 480},
+		{100, 100, 4, 24},
+	} {
+		r := &tempoRamp{from: c.from, to: c.to, start: 10, end: 10 + uint64(c.beats*c.ppqn)}
+		if got := r.bpm(r.start); got != c.from {
+			t.Errorf("%v→%v: expected %v bpm at the start, got %v", c.from, c.to, c.from, got)
+		}
+		if got, mid := r.bpm((r.start+r.end)/2), (c.from+c.to)/2; math.Abs(got-mid) > 1e-9 {
+			t.Errorf("%v→%v: expected %v bpm halfway, got %v", c.from, c.to, mid, got)
+		}
+		if got := r.bpm(r.end + 5); got != c.to {
+			t.Errorf("%v→%v: expected %v bpm after the end, got %v", c.from, c.to, c.to, got)
+		}
+
+		// A linear ramp over b beats takes 60b/(to-from) ln(to/from) s.
+		expected := 60 * float64(c.beats) / c.from
+		if c.from != c.to {
+			expected = 60 * float64(c.beats) / (c.to - c.from) * math.Log(c.to/c.from)
+		}
+		var total time.Duration
+		for n := r.start; n < r.end; n++ {
+			total += r.duration(n, c.ppqn)
+		}
+		if diff := math.Abs(total.Seconds() - expected); diff > 1e-6 {
+			t.Errorf("%v→%v over %d beats at %d ppqn: expected %.6fs, got %.6fs", c.from, c.to, c.beats, c.ppqn, expected, total.Seconds())
+		}
+	}
+}
+
+func TestTempoMapDescribe(t *testing.T) {
+	m := tempoMap{
+		17: {bpm: 140, over: 4, bars: true},
+		1:  {bpm: 120},
+		9:  {bpm: 90, over: 2},
+	}
+	expected := []string{
+		"bar 1: 120 bpm",
+		"bar 9: 90 bpm over 2 beats",
+		"bar 17: 140 bpm over 4 bars",
+	}
+	if got := m.describe(); !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %q, got %q", expected, got)
+	}
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// tuning maps (fractional) MIDI note numbers to frequencies. Without a scale
+// it's plain 12-TET around the reference key. A Scala scale and keyboard
+// mapping can be loaded for other temperaments and microtonal scales.
+type tuning struct {
+	refKey    int     // MIDI key with a known frequency
+	refHz     float64 // frequency of refKey
+	transpose float64 // semitones, may be fractional
+	cents     float64 // added on top of transpose
+	scale     *scale  // nil means 12-TET
+	keymap    *keymap // nil means linear, with degree 0 on middle C
+}
+
+var equalTemperament = tuning{refKey: 69, refHz: 440.0} // A4 = 440 Hz
+
+const (
+	maxRefHz     = 20000 // for a reference key
+	maxTranspose = 48    // semitones either way
+	maxCents     = 1200  // either way
+)
+
+// hz returns the frequency of the given note. Fractional notes are
+// interpolated (logarithmically) between their neighbouring keys, so they
+// make sense in any scale. Keys the keymap leaves unmapped return 0. Nothing
+// goes above Nyquist, where oscillators' phases would outrun a cycle a sample.
+func (t tuning) hz(note float64) float32 {
+	return float32(math.Min(t.unclamped(note), sRate/2))
+}
+
+func (t tuning) unclamped(note float64) float64 {
+	if note < 0 {
+		note = 0
+	}
+	if note > 127 {
+		note = 127
+	}
+	shift := math.Pow(2.0, (t.transpose+t.cents/100)/12.0)
+
+	if t.scale == nil && t.keymap == nil {
+		return shift * t.refHz * math.Pow(2.0, (note-float64(t.refKey))/12.0)
+	}
+
+	lo := math.Floor(note)
+	f0 := t.keyHz(int(lo))
+	if frac := note - lo; frac > 0 {
+		if f1 := t.keyHz(int(lo) + 1); f0 > 0 && f1 > 0 {
+			f0 *= math.Pow(f1/f0, frac)
+		}
+	}
+	return shift * f0
+}
+
+func (t tuning) keyHz(key int) float64 {
+	s, m := t.scale, t.keymap
+	if s == nil {
+		s = &twelveTone
+	}
+	if m == nil {
+		m = &keymap{middle: 60}
+	}
+	c, ok := m.cents(s, key)
+	if !ok {
+		return 0
+	}
+	ref, ok := m.cents(s, t.refKey)
+	if !ok {
+		return 0 // a reference key that isn't mapped is a broken keymap
+	}
+	return t.refHz * math.Pow(2.0, (c-ref)/1200.0)
+}
+
+// parseTuning applies a tuning command to t. Commands are:
+//
+//	a4 <hz>             reference pitch of A4
+//	ref <key> <hz>      reference pitch of any key
+//	transpose <semis>   transpose by (fractional) semitones
+//	cents <cents>       fine transpose
+//	scl <file>          load a Scala scale
+//	kbm <file>          load a Scala keyboard mapping
+//	reset               back to 12-TET with A4 = 440 Hz
+//
+// File names are used as given, so toks shouldn't be lowercased.
+func parseTuning(t tuning, toks []string) (tuning, error) {
+	if len(toks) <= 0 {
+		return t, fmt.Errorf("need a tuning command")
+	}
+
+	arg := func(i int) (float64, error) {
+		if len(toks) <= i {
+			return 0, fmt.Errorf("%s: not enough", toks[0])
+		}
+		return strconv.ParseFloat(toks[i], 64)
+	}
+
+	switch cmd := strings.ToLower(toks[0]); cmd {
+	case "a4":
+		hz, err := arg(1)
+		if err != nil {
+			return t, err
+		}
+		if hz <= 0 || hz > maxRefHz {
+			return t, fmt.Errorf("%s: want a frequency up to %v Hz", cmd, maxRefHz)
+		}
+		t.refKey, t.refHz = 69, hz
+
+	case "ref":
+		key, err := arg(1)
+		if err != nil {
+			return t, err
+		}
+		hz, err := arg(2)
+		if err != nil {
+			return t, err
+		}
+		if key < 0 || key > 127 || hz <= 0 || hz > maxRefHz {
+			return t, fmt.Errorf("%s: want a key 0..127 and a frequency up to %v Hz", cmd, maxRefHz)
+		}
+		t.refKey, t.refHz = int(key), hz
+
+	case "transpose", "trans":
+		semis, err := arg(1)
+		if err != nil {
+			return t, err
+		}
+		if math.Abs(semis) > maxTranspose {
+			return t, fmt.Errorf("%s: want -%v..%v semitones", cmd, maxTranspose, maxTranspose)
+		}
+		t.transpose = semis
+
+	case "cents":
+		cents, err := arg(1)
+		if err != nil {
+			return t, err
+		}
+		if math.Abs(cents) > maxCents {
+			return t, fmt.Errorf("%s: want -%v..%v cents", cmd, maxCents, maxCents)
+		}
+		t.cents = cents
+
+	case "scl":
+		if len(toks) < 2 {
+			return t, fmt.Errorf("%s: need a file", cmd)
+		}
+		s, err := loadScale(toks[1])
+		if err != nil {
+			return t, err
+		}
+		log.Printf("tuning: %s: %d notes (%s)", toks[1], len(s.cents), s.description)
+		t.scale = s
+
+	case "kbm":
+		if len(toks) < 2 {
+			return t, fmt.Errorf("%s: need a file", cmd)
+		}
+		m, err := loadKeymap(toks[1])
+		if err != nil {
+			return t, err
+		}
+		t.keymap = m
+		t.refKey, t.refHz = m.refKey, m.refHz
+
+	case "reset":
+		t = equalTemperament
+
+	default:
+		return t, fmt.Errorf("%s: aroo", cmd)
+	}
+	return t, nil
+}
+
+func (t tuning) String() string {
+	s := fmt.Sprintf("key %d = %.2f Hz", t.refKey, t.refHz)
+	if t.transpose != 0 || t.cents != 0 {
+		s += fmt.Sprintf(", transpose %+.2f semitones %+.1f cents", t.transpose, t.cents)
+	}
+	if t.scale != nil {
+		s += fmt.Sprintf(", scale %q", t.scale.description)
+	}
+	if t.keymap != nil {
+		s += ", keymap"
+	}
+	return s
+}
+
+// sharedTuning is the tuning generators follow unless they've been given
+// their own. It's changed from the platform, and read by every generator.
+type sharedTuning struct {
+	mtx sync.RWMutex
+	t   tuning
+}
+
+var globalTuning = &sharedTuning{t: equalTemperament}
+
+func (s *sharedTuning) get() tuning {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+	return s.t
+}
+
+func (s *sharedTuning) set(t tuning) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.t = t
+}
+
+// nodeTuning is the tuning of a node: its own, or the global one.
+type nodeTuning struct {
+	own *tuning // nil means follow globalTuning
+}
+
+func (n *nodeTuning) get() tuning {
+	if n.own == nil {
+		return globalTuning.get()
+	}
+	return *n.own
+}
+
+// apply applies a tuning command (see parseTuning) to the node's tuning,
+// starting from the global one if it doesn't have its own yet. "global" puts
+// the node back on the global tuning.
+func (n *nodeTuning) apply(toks []string) error {
+	if len(toks) == 1 && strings.ToLower(toks[0]) == "global" {
+		n.own = nil
+		return nil
+	}
+	t, err := parseTuning(n.get(), toks)
+	if err != nil {
+		return err
+	}
+	n.own = &t
+	return nil
+}
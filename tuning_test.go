@@ -0,0 +1,127 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTuningHz(t *testing.T) {
+	just := mustScale(t, `! just.scl
+Five-limit just intonation
+ 12
+!
+ 16/15
+ 9/8
+ 6/5
+ 5/4
+ 4/3
+ 45/32
+ 3/2
+ 8/5
+ 5/3
+ 9/5
+ 15/8
+ 2/1
+`)
+
+	for _, c := range []struct {
+		name     string
+		t        tuning
+		note     float64
+		expected float32
+	}{
+		{"A4", equalTemperament, 69, 440.00},
+		{"A4=432", tuning{refKey: 69, refHz: 432}, 69, 432.00},
+		{"A4=432 C4", tuning{refKey: 69, refHz: 432}, 60, 256.87},
+		{"transpose", tuning{refKey: 69, refHz: 440, transpose: -12}, 69, 220.00},
+		{"cents", tuning{refKey: 69, refHz: 440, cents: 100}, 69, 466.16},
+		{"quarter tone", equalTemperament, 69.5, 452.89},
+		{"just C4", tuning{refKey: 60, refHz: 261.63, scale: just}, 60, 261.63},
+		{"just E4", tuning{refKey: 60, refHz: 261.63, scale: just}, 64, 327.04},
+		{"just G4", tuning{refKey: 60, refHz: 261.63, scale: just}, 67, 392.45},
+		{"just C5", tuning{refKey: 60, refHz: 261.63, scale: just}, 72, 523.26},
+		{"just B3", tuning{refKey: 60, refHz: 261.63, scale: just}, 59, 245.28},
+	} {
+		if got := c.t.hz(c.note); !cmpFloat32(got, c.expected, 0.01) {
+			t.Errorf("%s: expected %.4f, got %.4f", c.name, c.expected, got)
+		}
+	}
+}
+
+func TestKeymap(t *testing.T) {
+	// A pentatonic scale on the white keys, with the black keys unmapped.
+	penta := mustScale(t, `Pentatonic
+5
+200.0
+400.0
+700.0
+900.0
+2/1
+`)
+	m, err := readKeymap(strings.NewReader(`! white keys only
+12
+0
+127
+60
+69
+440.0
+5
+0
+x
+1
+x
+2
+x
+x
+3
+x
+4
+x
+x
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tun := tuning{refKey: m.refKey, refHz: m.refHz, scale: penta, keymap: m}
+
+	for note, expected := range map[float64]float32{
+		69: 440.00, // A4, degree 4
+		60: 261.63, // C4, degree 0
+		62: 293.66, // D4, degree 1
+		61: 0,      // C#4, unmapped
+		72: 523.25, // C5, degree 0 of the next repeat
+	} {
+		if got := tun.hz(note); !cmpFloat32(got, expected, 0.01) {
+			t.Errorf("%.0f: expected %.4f, got %.4f", note, expected, got)
+		}
+	}
+}
+
+func mustScale(t *testing.T, s string) *scale {
+	sc, err := readScale(strings.NewReader(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sc
+}
+
+func TestParseTuningBounds(t *testing.T) {
+	for _, x := range []struct {
+		toks []string
+		ok   bool
+	}{
+		{[]string{"a4", "432"}, true},
+		{[]string{"a4", "20000"}, true},
+		{[]string{"a4", "20001"}, false},
+		{[]string{"a4", "0"}, false},
+		{[]string{"ref", "60", "1e9"}, false},
+		{[]string{"transpose", "48"}, true},
+		{[]string{"transpose", "-49"}, false},
+		{[]string{"cents", "1200"}, true},
+		{[]string{"cents", "1e6"}, false},
+	} {
+		if _, err := parseTuning(equalTemperament, x.toks); (err == nil) != x.ok {
+			t.Errorf("%v: expected ok %v, got %v", x.toks, x.ok, err)
+		}
+	}
+}
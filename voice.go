@@ -0,0 +1,139 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+)
+
+const (
+	maxUnison = 16
+	maxVoices = 64
+)
+
+// voiceParams are the generator parameters that shape how a voice sounds.
+type voiceParams struct {
+	wave    waveform
+	width   float32 // of the waveform, for those that have one
+	unison  int     // oscillators per voice
+	detune  float32 // cents between the outermost unison oscillators
+	attack  float64 // seconds from silence to full level
+	release float64 // seconds from full level to silence
+}
+
+// voice is one sounding note of a generator: a set of unison oscillators
+// behind a linear attack/release envelope. A voice's pitch can glide.
+type voice struct {
+	pitch   float64   // current MIDI note, fractional while gliding
+	target  float64   // MIDI note pitch is gliding to
+	slope   float64   // semitones per sample while gliding
+	phases  []float32 // one per unison oscillator
+	level   float32   // envelope
+	held    bool      // false once released
+	started uint64    // order it started in, for voice stealing
+}
+
+// newVoice starts a voice with n unison oscillators, at random phases so that
+// they don't begin in lockstep.
+func newVoice(key float64, n int) *voice {
+	v := &voice{
+		pitch:  key,
+		target: key,
+		held:   true,
+	}
+	v.resize(n)
+	return v
+}
+
+func (v *voice) resize(n int) {
+	for len(v.phases) < n {
+		v.phases = append(v.phases, rand.Float32())
+	}
+	v.phases = v.phases[:n]
+}
+
+// glideTo moves the voice to a new key over the given number of seconds.
+func (v *voice) glideTo(key float64, seconds float64) {
+	v.target = key
+	if seconds <= 0 {
+		v.pitch, v.slope = v.target, 0
+		return
+	}
+	v.slope = math.Abs(v.target-v.pitch) / (seconds * sRate)
+}
+
+// render adds the next bufSz samples of the voice to buf, with its pitch moved
+// by offsets semitones, one per sample, or none if nil. It returns false once
+// the voice is released and silent.
+func (v *voice) render(buf []float32, p voiceParams, t tuning, offsets []float64) bool {
+	v.resize(p.unison)
+	gain := float32(1 / math.Sqrt(float64(p.unison)))
+	up, down := envelopeStep(p.attack), envelopeStep(p.release)
+
+	ratios := make([]float32, len(v.phases))
+	for j := range ratios {
+		ratios[j] = cents2ratio(unisonOffset(j, len(ratios), p.detune))
+	}
+
+	offset := 0.0
+	if offsets != nil {
+		offset = offsets[0]
+	}
+	hz := t.hz(v.pitch + offset)
+	for i := range buf {
+		moved := v.pitch != v.target
+		if moved {
+			v.pitch = approach(v.pitch, v.target, v.slope)
+		}
+		if offsets != nil && offsets[i] != offset {
+			offset, moved = offsets[i], true
+		}
+		if moved {
+			hz = t.hz(v.pitch + offset)
+		}
+
+		if v.held {
+			v.level = float32(math.Min(1, float64(v.level+up)))
+		} else {
+			v.level = float32(math.Max(0, float64(v.level-down)))
+		}
+		if v.level <= 0 && !v.held {
+			return false
+		}
+
+		for j := range v.phases {
+			buf[i] += v.level * gain * nextWaveformValue(p.wave, p.width, hz*ratios[j], &v.phases[j])
+		}
+	}
+	return true
+}
+
+// envelopeStep is the per-sample change in level for a ramp of the given
+// length. Zero-length ramps are instant.
+func envelopeStep(seconds float64) float32 {
+	if seconds <= 0 {
+		return 1
+	}
+	return float32(1 / (seconds * sRate))
+}
+
+func approach(from, to, step float64) float64 {
+	switch {
+	case from < to:
+		return math.Min(from+step, to)
+	case from > to:
+		return math.Max(from-step, to)
+	}
+	return to
+}
+
+// unisonOffset is the detune in cents of oscillator j of n.
+func unisonOffset(j, n int, detune float32) float64 {
+	if n <= 1 {
+		return 0
+	}
+	return float64(detune) * (float64(j)/float64(n-1) - 0.5)
+}
+
+func cents2ratio(cents float64) float32 {
+	return float32(math.Pow(2.0, cents/1200.0))
+}
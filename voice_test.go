@@ -0,0 +1,92 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func testVoiceParams() voiceParams {
+	return voiceParams{wave: waveforms.get("sine"), width: 0.5, unison: 1}
+}
+
+func TestVoiceGlide(t *testing.T) {
+	v := newVoice(60, 1)
+	v.glideTo(72, 0.1) // 120 semitones a second
+	p := testVoiceParams()
+	rendered := 0 // samples
+	for _, seconds := range []float64{0.025, 0.05, 0.1, 0.2} {
+		for float64(rendered) < seconds*sRate {
+			v.render(make([]float32, bufSz), p, equalTemperament, nil)
+			rendered += bufSz
+		}
+		expected := math.Min(72, 60+120*float64(rendered)/sRate)
+		if math.Abs(v.pitch-expected) > 1e-6 {
+			t.Errorf("%.3fs into the glide: expected %.3f, got %.3f", float64(rendered)/sRate, expected, v.pitch)
+		}
+	}
+}
+
+func TestVoiceEnvelope(t *testing.T) {
+	p := testVoiceParams()
+	p.attack, p.release = 0.010, 0.020
+	v := newVoice(69, 1)
+
+	buffers := func(seconds float64) int { return int(math.Ceil(seconds * sRate / bufSz)) }
+	for i := 0; i < buffers(0.010)-1; i++ {
+		v.render(make([]float32, bufSz), p, equalTemperament, nil)
+	}
+	if v.level >= 1 {
+		t.Errorf("expected the attack still rising before 10ms, got %v", v.level)
+	}
+	v.render(make([]float32, bufSz), p, equalTemperament, nil)
+	if v.level != 1 {
+		t.Errorf("expected full level after 10ms, got %v", v.level)
+	}
+
+	v.held = false
+	alive := 0
+	for v.render(make([]float32, bufSz), p, equalTemperament, nil) {
+		alive++
+	}
+	if expected := buffers(0.020) - 1; alive < expected-1 || alive > expected {
+		t.Errorf("expected about %d buffers of release, got %d", expected, alive)
+	}
+}
+
+func TestVoiceUnison(t *testing.T) {
+	p := testVoiceParams()
+	p.unison, p.detune = 2, 100 // a quarter tone either side
+	buf := make([]float32, 8*bufSz)
+	newVoice(69, p.unison).render(buf, p, equalTemperament, nil)
+
+	flat, sharp := 440*math.Pow(2, -50.0/1200), 440*math.Pow(2, 50.0/1200)
+	for _, hz := range []float64{flat, sharp} {
+		if m := magnitude(buf, hz); m < 0.6 {
+			t.Errorf("expected an oscillator at %.1f Hz, got %.3f", hz, m)
+		}
+	}
+	if m := magnitude(buf, 440); m > 0.25 {
+		t.Errorf("expected nothing at 440 Hz between the oscillators, got %.3f", m)
+	}
+}
+
+func TestVoicePhases(t *testing.T) {
+	p := testVoiceParams()
+	a, b := make([]float32, bufSz), make([]float32, bufSz)
+	newVoice(69, 1).render(a, p, equalTemperament, nil)
+	newVoice(69, 1).render(b, p, equalTemperament, nil)
+	same := true
+	for i := range a {
+		same = same && a[i] == b[i]
+	}
+	if same {
+		t.Errorf("expected voices on the same key to start at different phases")
+	}
+
+	v := newVoice(69, 4)
+	for j := 1; j < len(v.phases); j++ {
+		if v.phases[j] == v.phases[0] {
+			t.Errorf("expected unison oscillators at different phases, got %v", v.phases)
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+)
+
+// sample is a sound loaded from a file, mixed down to mono.
+type sample struct {
+	data []float32
+	rate float64 // the file's sample rate, in Hz
+}
+
+// at reads the sample at a fractional position, interpolating linearly and
+// wrapping around the ends.
+func (s *sample) at(pos float64) float32 {
+	n := len(s.data)
+	if n <= 0 {
+		return 0
+	}
+	pos = math.Mod(pos, float64(n))
+	if pos < 0 {
+		pos += float64(n)
+	}
+	i := int(pos)
+	frac := float32(pos - float64(i))
+	return (1-frac)*s.data[i%n] + frac*s.data[(i+1)%n]
+}
+
+const (
+	wavPCM        = 1
+	wavFloat      = 3
+	wavExtensible = 0xfffe
+)
+
+func loadWAV(filename string) (*sample, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return readWAV(f)
+}
+
+// readWAV decodes a RIFF WAVE file: integer PCM of 8, 16, 24 or 32 bits, or
+// 32 or 64 bit float. Channels are averaged into one.
+func readWAV(r io.Reader) (*sample, error) {
+	buf, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(buf) < 12 || string(buf[0:4]) != "RIFF" || string(buf[8:12]) != "WAVE" {
+		return nil, fmt.Errorf("wav: not a RIFF WAVE file")
+	}
+
+	var (
+		format, channels, bits int
+		rate                   float64
+		data                   []byte
+		gotFormat              bool
+	)
+	for p := 12; p+8 <= len(buf); {
+		id, size := string(buf[p:p+4]), int(binary.LittleEndian.Uint32(buf[p+4:p+8]))
+		p += 8
+		if size > len(buf)-p {
+			size = len(buf) - p // truncated; take what's there
+		}
+		chunk := buf[p : p+size]
+		switch id {
+		case "fmt ":
+			if size < 16 {
+				return nil, fmt.Errorf("wav: short fmt chunk")
+			}
+			format = int(binary.LittleEndian.Uint16(chunk[0:2]))
+			channels = int(binary.LittleEndian.Uint16(chunk[2:4]))
+			rate = float64(binary.LittleEndian.Uint32(chunk[4:8]))
+			bits = int(binary.LittleEndian.Uint16(chunk[14:16]))
+			if format == wavExtensible && size >= 26 {
+				format = int(binary.LittleEndian.Uint16(chunk[24:26])) // sub-format
+			}
+			gotFormat = true
+		case "data":
+			data = chunk
+		}
+		p += size + size%2 // chunks are padded to even sizes
+	}
+	if !gotFormat {
+		return nil, fmt.Errorf("wav: no fmt chunk")
+	}
+	if data == nil {
+		return nil, fmt.Errorf("wav: no data chunk")
+	}
+	if channels <= 0 || rate <= 0 {
+		return nil, fmt.Errorf("wav: bad format (%d channels at %v Hz)", channels, rate)
+	}
+
+	decode, err := wavDecoder(format, bits)
+	if err != nil {
+		return nil, err
+	}
+	width := bits / 8
+	frames := len(data) / (width * channels)
+	s := &sample{data: make([]float32, frames), rate: rate}
+	for i := range s.data {
+		var sum float32
+		for c := 0; c < channels; c++ {
+			o := (i*channels + c) * width
+			sum += decode(data[o : o+width])
+		}
+		s.data[i] = sum / float32(channels)
+	}
+	return s, nil
+}
+
+// wavDecoder returns a function that turns one sample's bytes into [-1..1].
+func wavDecoder(format, bits int) (func([]byte) float32, error) {
+	switch {
+	case format == wavPCM && bits == 8:
+		return func(b []byte) float32 { return (float32(b[0]) - 128) / 128 }, nil
+	case format == wavPCM && bits == 16:
+		return func(b []byte) float32 {
+			return float32(int16(binary.LittleEndian.Uint16(b))) / (1 << 15)
+		}, nil
+	case format == wavPCM && bits == 24:
+		return func(b []byte) float32 {
+			v := int32(uint32(b[0])<<8|uint32(b[1])<<16|uint32(b[2])<<24) >> 8
+			return float32(v) / (1 << 23)
+		}, nil
+	case format == wavPCM && bits == 32:
+		return func(b []byte) float32 {
+			return float32(int32(binary.LittleEndian.Uint32(b))) / (1 << 31)
+		}, nil
+	case format == wavFloat && bits == 32:
+		return func(b []byte) float32 {
+			return math.Float32frombits(binary.LittleEndian.Uint32(b))
+		}, nil
+	case format == wavFloat && bits == 64:
+		return func(b []byte) float32 {
+			return float32(math.Float64frombits(binary.LittleEndian.Uint64(b)))
+		}, nil
+	}
+	return nil, fmt.Errorf("wav: unsupported format %d, %d bits", format, bits)
+}
+
+// resample returns the sample at another rate, interpolating linearly.
+func (s *sample) resample(rate float64) *sample {
+	if rate == s.rate || len(s.data) <= 0 {
+		return s
+	}
+	n := int(float64(len(s.data)) * rate / s.rate)
+	out := &sample{data: make([]float32, n), rate: rate}
+	for i := range out.data {
+		pos := float64(i) * s.rate / rate
+		j := int(pos)
+		frac := float32(pos - float64(j))
+		next := s.data[len(s.data)-1]
+		if j+1 < len(s.data) {
+			next = s.data[j+1]
+		}
+		out.data[i] = (1-frac)*s.data[j] + frac*next
+	}
+	return out
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"testing"
+)
+
+func TestReadWAV(t *testing.T) {
+	for _, c := range []struct {
+		name     string
+		format   uint16
+		channels uint16
+		bits     uint16
+		data     []byte
+		expected []float32
+	}{
+		{
+			name: "16-bit mono", format: wavPCM, channels: 1, bits: 16,
+			data:     le(int16(0), int16(16384), int16(-32768)),
+			expected: []float32{0, 0.5, -1},
+		},
+		{
+			name: "16-bit stereo", format: wavPCM, channels: 2, bits: 16,
+			data:     le(int16(16384), int16(0), int16(-16384), int16(-16384)),
+			expected: []float32{0.25, -0.5},
+		},
+		{
+			name: "8-bit", format: wavPCM, channels: 1, bits: 8,
+			data:     []byte{128, 192, 0},
+			expected: []float32{0, 0.5, -1},
+		},
+		{
+			name: "24-bit", format: wavPCM, channels: 1, bits: 24,
+			data:     []byte{0x00, 0x00, 0x40, 0x00, 0x00, 0xc0},
+			expected: []float32{0.5, -0.5},
+		},
+		{
+			name: "float", format: wavFloat, channels: 1, bits: 32,
+			data:     le(math.Float32bits(0.25), math.Float32bits(-0.75)),
+			expected: []float32{0.25, -0.75},
+		},
+	} {
+		s, err := readWAV(bytes.NewReader(wavFile(c.format, c.channels, c.bits, c.data)))
+		if err != nil {
+			t.Errorf("%s: %s", c.name, err)
+			continue
+		}
+		if s.rate != 48000 {
+			t.Errorf("%s: expected rate 48000, got %v", c.name, s.rate)
+		}
+		if len(s.data) != len(c.expected) {
+			t.Errorf("%s: expected %d samples, got %d", c.name, len(c.expected), len(s.data))
+			continue
+		}
+		for i := range s.data {
+			if !cmpFloat32(s.data[i], c.expected[i], 0.0001) {
+				t.Errorf("%s: sample %d: expected %.4f, got %.4f", c.name, i, c.expected[i], s.data[i])
+			}
+		}
+	}
+}
+
+func TestReadWAVErrors(t *testing.T) {
+	for _, c := range []struct {
+		name string
+		file []byte
+	}{
+		{"not riff", []byte("hello, world")},
+		{"no data", wavFile(wavPCM, 1, 16, nil)[:36]},
+		{"unsupported", wavFile(wavPCM, 1, 12, []byte{0, 0})},
+	} {
+		if _, err := readWAV(bytes.NewReader(c.file)); err == nil {
+			t.Errorf("%s: expected error", c.name)
+		}
+	}
+}
+
+func wavFile(format, channels, bits uint16, data []byte) []byte {
+	const rate = 48000
+	align := channels * bits / 8
+	b := &bytes.Buffer{}
+	b.WriteString("RIFF")
+	binary.Write(b, binary.LittleEndian, uint32(4+8+16+8+len(data)))
+	b.WriteString("WAVE")
+	b.WriteString("fmt ")
+	binary.Write(b, binary.LittleEndian, uint32(16))
+	binary.Write(b, binary.LittleEndian, []uint16{format, channels})
+	binary.Write(b, binary.LittleEndian, []uint32{rate, rate * uint32(align)})
+	binary.Write(b, binary.LittleEndian, []uint16{align, bits})
+	b.WriteString("data")
+	binary.Write(b, binary.LittleEndian, uint32(len(data)))
+	b.Write(data)
+	return b.Bytes()
+}
+
+func le(values ...interface{}) []byte {
+	b := &bytes.Buffer{}
+	for _, v := range values {
+		binary.Write(b, binary.LittleEndian, v)
+	}
+	return b.Bytes()
+}